@@ -3,8 +3,12 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/dejavu/backend/internal/handler"
+	"github.com/dejavu/backend/internal/repository"
+	"github.com/dejavu/backend/internal/service"
 	"github.com/dejavu/backend/pkg/cache"
 	"github.com/dejavu/backend/pkg/database"
 	"github.com/dejavu/backend/pkg/queue"
@@ -37,6 +41,36 @@ func main() {
 	}
 	defer nats.Close()
 
+	// Deliver outbound deployment webhooks
+	dispatcher := service.NewWebhookDispatcher(
+		repository.NewWebhookRepository(db),
+		repository.NewDeploymentRepository(db),
+		nats,
+	)
+	if err := dispatcher.Start(); err != nil {
+		log.Fatal("Failed to start webhook dispatcher:", err)
+	}
+
+	// Fail deployments their builder or deployer gave up on
+	service.NewDeploymentReaper(repository.NewDeploymentRepository(db), nats).Start()
+
+	// Persist streamed build logs and prune old ones
+	logService := service.NewBuildLogService(
+		repository.NewBuildLogRepository(db),
+		repository.NewDeploymentRepository(db),
+		repository.NewProjectRepository(db),
+		nats,
+	)
+	if err := logService.StartCollector(); err != nil {
+		log.Fatal("Failed to start log collector:", err)
+	}
+
+	retentionDays, err := strconv.Atoi(os.Getenv("LOG_RETENTION_DAYS"))
+	if err != nil || retentionDays <= 0 {
+		retentionDays = 30
+	}
+	logService.StartRetention(time.Duration(retentionDays) * 24 * time.Hour)
+
 	// Initialize Fiber app
 	app := fiber.New(fiber.Config{
 		AppName: "Dejavu API",
@@ -60,8 +94,13 @@ func main() {
 
 	// Initialize handlers
 	authHandler := handler.NewAuthHandler(db, redis)
-	projectHandler := handler.NewProjectHandler(db)
+	projectHandler := handler.NewProjectHandler(db, nats)
 	deployHandler := handler.NewDeployHandler(db, nats)
+	envVarHandler := handler.NewEnvVarHandler(db)
+	webhookHandler := handler.NewWebhookHandler(db, nats)
+	webhookEndpointHandler := handler.NewWebhookEndpointHandler(db)
+	domainHandler := handler.NewDomainHandler(db, nats)
+	gitCredentialHandler := handler.NewGitCredentialHandler(db)
 
 	// Routes
 	api := app.Group("/api")
@@ -71,9 +110,14 @@ func main() {
 	auth.Post("/register", authHandler.Register)
 	auth.Post("/login", authHandler.Login)
 
+	// Git provider webhooks (authenticated by signature)
+	api.Post("/webhooks/:provider/:projectID", webhookHandler.Push)
+
 	// Protected routes
 	api.Use(handler.AuthMiddleware(redis))
 
+	api.Get("/plans", projectHandler.ListPlans)
+
 	// Project routes
 	projects := api.Group("/projects")
 	projects.Get("/", projectHandler.List)
@@ -81,12 +125,35 @@ func main() {
 	projects.Get("/:id", projectHandler.Get)
 	projects.Put("/:id", projectHandler.Update)
 	projects.Delete("/:id", projectHandler.Delete)
+	projects.Post("/:id/analyze", deployHandler.Analyze)
+	projects.Get("/:id/env", envVarHandler.List)
+	projects.Post("/:id/env", envVarHandler.Create)
+	projects.Put("/:id/env/:envID", envVarHandler.Update)
+	projects.Delete("/:id/env/:envID", envVarHandler.Delete)
+	projects.Get("/:id/webhooks", webhookEndpointHandler.List)
+	projects.Post("/:id/webhooks", webhookEndpointHandler.Create)
+	projects.Put("/:id/webhooks/:webhookID", webhookEndpointHandler.Update)
+	projects.Delete("/:id/webhooks/:webhookID", webhookEndpointHandler.Delete)
+	projects.Get("/:id/webhooks/:webhookID/deliveries", webhookEndpointHandler.ListDeliveries)
+	projects.Get("/:id/domains", domainHandler.List)
+	projects.Post("/:id/domains", domainHandler.Create)
+	projects.Post("/:id/domains/:domainID/verify", domainHandler.Verify)
+	projects.Delete("/:id/domains/:domainID", domainHandler.Delete)
+	projects.Get("/:id/credentials", gitCredentialHandler.Get)
+	projects.Post("/:id/credentials/deploy-key", gitCredentialHandler.GenerateDeployKey)
+	projects.Put("/:id/credentials/token", gitCredentialHandler.SetToken)
+	projects.Put("/:id/credentials/known-hosts", gitCredentialHandler.SetKnownHosts)
+	projects.Delete("/:id/credentials", gitCredentialHandler.Delete)
 
 	// Deployment routes
 	deploy := api.Group("/deploy")
 	deploy.Post("/", deployHandler.Trigger)
 	deploy.Get("/:id", deployHandler.GetStatus)
 	deploy.Get("/:id/logs", deployHandler.StreamLogs)
+	deploy.Get("/:id/timeline", deployHandler.Timeline)
+	deploy.Post("/:id/rollback", deployHandler.Rollback)
+	deploy.Post("/:id/promote", deployHandler.Promote)
+	deploy.Post("/:id/cancel", deployHandler.Cancel)
 
 	// Start server
 	port := os.Getenv("PORT")
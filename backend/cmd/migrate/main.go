@@ -5,6 +5,8 @@ import (
 	"log"
 	"os"
 
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/internal/repository"
 	"github.com/dejavu/backend/pkg/database"
 	"github.com/joho/godotenv"
 )
@@ -85,10 +87,159 @@ func migrateUp(db *database.DB) error {
 			description TEXT,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
+		`CREATE TABLE IF NOT EXISTS env_vars (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			key VARCHAR(255) NOT NULL,
+			value TEXT NOT NULL,
+			scope VARCHAR(20) NOT NULL DEFAULT 'runtime',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (project_id, key, scope)
+		)`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS production_branch VARCHAR(255) NOT NULL DEFAULT 'main'`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS webhook_secret VARCHAR(64) NOT NULL DEFAULT replace(gen_random_uuid()::text || gen_random_uuid()::text, '-', '')`,
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			secret VARCHAR(64) NOT NULL,
+			events TEXT[] NOT NULL,
+			active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+			deployment_id UUID NOT NULL REFERENCES deployments(id) ON DELETE CASCADE,
+			event VARCHAR(50) NOT NULL,
+			attempt INT NOT NULL,
+			status_code INT NOT NULL DEFAULT 0,
+			success BOOLEAN NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS build_log_lines (
+			deployment_id UUID NOT NULL REFERENCES deployments(id) ON DELETE CASCADE,
+			seq BIGINT NOT NULL,
+			timestamp TIMESTAMP NOT NULL,
+			step VARCHAR(50) NOT NULL DEFAULT '',
+			level VARCHAR(20) NOT NULL DEFAULT 'info',
+			message TEXT NOT NULL,
+			PRIMARY KEY (deployment_id, seq)
+		)`,
+		`ALTER TABLE deployments ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'build'`,
+		`ALTER TABLE deployments ADD COLUMN IF NOT EXISTS source_deployment_id UUID REFERENCES deployments(id) ON DELETE SET NULL`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS production_deployment_id UUID REFERENCES deployments(id) ON DELETE SET NULL`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS slug VARCHAR(63)`,
+		`UPDATE projects
+			SET slug = COALESCE(NULLIF(trim(both '-' from regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g')), ''), 'project')
+				|| '-' || substr(id::text, 1, 6)
+			WHERE slug IS NULL`,
+		`ALTER TABLE projects ALTER COLUMN slug SET NOT NULL`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_projects_slug ON projects(slug)`,
+		`CREATE TABLE IF NOT EXISTS domains (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			project_id UUID REFERENCES projects(id) ON DELETE CASCADE,
+			hostname VARCHAR(253) UNIQUE NOT NULL,
+			verification_token VARCHAR(64) NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			tls_status VARCHAR(20) NOT NULL DEFAULT 'none',
+			last_error TEXT NOT NULL DEFAULT '',
+			verified_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE deployments ADD COLUMN IF NOT EXISTS error_message TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS port INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS health_checks JSONB NOT NULL DEFAULT '{}'`,
+		`ALTER TABLE deployments ADD COLUMN IF NOT EXISTS port INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE billing_accounts ADD COLUMN IF NOT EXISTS tier VARCHAR(20) NOT NULL DEFAULT 'hobby'`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS plan VARCHAR(20) NOT NULL DEFAULT 'hobby'`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS scaling JSONB NOT NULL DEFAULT '{"cpu_request": 100, "cpu_limit": 500, "memory_request": 128, "memory_limit": 512, "min_replicas": 1, "max_replicas": 2, "target_cpu": 80, "target_memory": 0, "scale_to_zero": true}'`,
+		`ALTER TABLE deployments ADD COLUMN IF NOT EXISTS ref VARCHAR(255) NOT NULL DEFAULT ''`,
+		`ALTER TABLE deployments ADD COLUMN IF NOT EXISTS ref_type VARCHAR(20) NOT NULL DEFAULT ''`,
+		`ALTER TABLE deployments ADD COLUMN IF NOT EXISTS commit_author VARCHAR(255) NOT NULL DEFAULT ''`,
+		`ALTER TABLE deployments ADD COLUMN IF NOT EXISTS commit_message TEXT NOT NULL DEFAULT ''`,
+		`CREATE TABLE IF NOT EXISTS git_credentials (
+			project_id UUID PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+			type VARCHAR(20) NOT NULL,
+			username VARCHAR(255) NOT NULL DEFAULT '',
+			public_key TEXT NOT NULL DEFAULT '',
+			secret TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS root_directory VARCHAR(255) NOT NULL DEFAULT ''`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS install_command TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS framework VARCHAR(50) NOT NULL DEFAULT ''`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS docker JSONB NOT NULL DEFAULT '{}'`,
+		`CREATE TABLE IF NOT EXISTS deployment_events (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			seq BIGSERIAL,
+			deployment_id UUID NOT NULL REFERENCES deployments(id) ON DELETE CASCADE,
+			from_status VARCHAR(50) NOT NULL DEFAULT '',
+			to_status VARCHAR(50) NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`INSERT INTO deployment_events (deployment_id, to_status, created_at)
+			SELECT id, status, created_at FROM deployments d
+			WHERE NOT EXISTS (SELECT 1 FROM deployment_events e WHERE e.deployment_id = d.id)`,
+		`CREATE TABLE IF NOT EXISTS webhook_jobs (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+			deployment_id UUID NOT NULL REFERENCES deployments(id) ON DELETE CASCADE,
+			event VARCHAR(50) NOT NULL,
+			payload TEXT NOT NULL,
+			attempt INT NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// Move the log blobs of deployments from before structured logs into
+		// lines, then drop them
+		`DO $$
+		BEGIN
+			IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'deployments' AND column_name = 'build_logs') THEN
+				INSERT INTO build_log_lines (deployment_id, seq, timestamp, message)
+					SELECT d.id, l.seq, d.updated_at, l.message
+					FROM deployments d, regexp_split_to_table(rtrim(d.build_logs, E'\n'), E'\n') WITH ORDINALITY AS l(message, seq)
+					WHERE d.build_logs <> ''
+					AND NOT EXISTS (SELECT 1 FROM build_log_lines b WHERE b.deployment_id = d.id);
+				ALTER TABLE deployments DROP COLUMN build_logs;
+			END IF;
+		END $$`,
+		// Unverified claims must not block the owner of a hostname
+		`ALTER TABLE domains DROP CONSTRAINT IF EXISTS domains_hostname_key`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_domains_verified_hostname ON domains(hostname) WHERE status = 'verified'`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_domains_project_hostname ON domains(project_id, hostname)`,
+		// The hobby defaults, scale to zero included
+		`ALTER TABLE projects ALTER COLUMN scaling SET DEFAULT '{"cpu_request": 100, "cpu_limit": 500, "memory_request": 128, "memory_limit": 512, "min_replicas": 1, "max_replicas": 2, "target_cpu": 80, "target_memory": 0, "scale_to_zero": true}'`,
+		`ALTER TABLE git_credentials ADD COLUMN IF NOT EXISTS known_hosts TEXT NOT NULL DEFAULT ''`,
+		// Unset build settings are detected by the builder
+		`ALTER TABLE projects ALTER COLUMN build_command SET DEFAULT ''`,
+		`ALTER TABLE projects ALTER COLUMN output_dir SET DEFAULT ''`,
+		// Set by the deployer when it starts a rollout
+		`ALTER TABLE deployments ADD COLUMN IF NOT EXISTS rollout_deadline TIMESTAMP`,
+		// Data rewrites that must run only once, see backfills
+		`CREATE TABLE IF NOT EXISTS backfills (
+			name VARCHAR(100) PRIMARY KEY,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// The deployment state machine, for the deployer; filled below
+		`CREATE TABLE IF NOT EXISTS deployment_transitions (
+			from_status VARCHAR(50) NOT NULL,
+			to_status VARCHAR(50) NOT NULL,
+			PRIMARY KEY (from_status, to_status)
+		)`,
 		`CREATE INDEX IF NOT EXISTS idx_projects_user_id ON projects(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_deployments_project_id ON deployments(project_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_deployments_status ON deployments(status)`,
 		`CREATE INDEX IF NOT EXISTS idx_usage_records_user_id ON usage_records(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhooks_project_id ON webhooks(project_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_build_log_lines_timestamp ON build_log_lines(timestamp)`,
+		`CREATE INDEX IF NOT EXISTS idx_domains_project_id ON domains(project_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_deployment_events_deployment_id ON deployment_events(deployment_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_jobs_next_attempt_at ON webhook_jobs(next_attempt_at)`,
 	}
 
 	for i, migration := range migrations {
@@ -98,11 +249,71 @@ func migrateUp(db *database.DB) error {
 		}
 	}
 
+	// Unlike the migrations above, backfills change data users may change
+	// back afterwards, so each runs once, recorded by name in backfills
+	backfills := []struct{ name, query string }{
+		// The old defaults of unset build settings, which the builder ignored
+		{"reset_default_build_command", `UPDATE projects SET build_command = '' WHERE build_command = 'npm run build'`},
+		{"reset_default_output_dir", `UPDATE projects SET output_dir = '' WHERE output_dir = 'dist'`},
+		// Generated static and PHP images moved from port 80 to 8080; drop the
+		// old port where it was set to match
+		{"reset_static_port_80", `UPDATE projects SET port = 0 WHERE port = 80 AND (framework IN ('static', 'vite', 'astro', 'php')
+			OR (framework = '' AND id IN (SELECT project_id FROM deployments WHERE port = 80)))`},
+	}
+
+	for _, backfill := range backfills {
+		applied, err := runBackfill(db, backfill.name, backfill.query)
+		if err != nil {
+			return fmt.Errorf("backfill %s failed: %w", backfill.name, err)
+		}
+		if applied {
+			fmt.Printf("Applied backfill %s\n", backfill.name)
+		}
+	}
+
+	// Keep the deployer's copy of the state machine in step with domain
+	if err := repository.NewDeploymentRepository(db).SyncTransitions(domain.DeploymentTransitions()); err != nil {
+		return fmt.Errorf("storing deployment transitions failed: %w", err)
+	}
+
 	return nil
 }
 
+// runBackfill runs query unless a backfill of that name ran before, and
+// records it in the same transaction
+func runBackfill(db *database.DB, name, query string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO backfills (name) VALUES ($1) ON CONFLICT DO NOTHING", name)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	if _, err := tx.Exec(query); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
 func migrateDown(db *database.DB) error {
 	migrations := []string{
+		`DROP TABLE IF EXISTS backfills CASCADE`,
+		`DROP TABLE IF EXISTS deployment_transitions CASCADE`,
+		`DROP TABLE IF EXISTS webhook_jobs CASCADE`,
+		`DROP TABLE IF EXISTS deployment_events CASCADE`,
+		`DROP TABLE IF EXISTS git_credentials CASCADE`,
+		`DROP TABLE IF EXISTS domains CASCADE`,
+		`DROP TABLE IF EXISTS build_log_lines CASCADE`,
+		`DROP TABLE IF EXISTS webhook_deliveries CASCADE`,
+		`DROP TABLE IF EXISTS webhooks CASCADE`,
+		`DROP TABLE IF EXISTS env_vars CASCADE`,
 		`DROP TABLE IF EXISTS usage_records CASCADE`,
 		`DROP TABLE IF EXISTS billing_accounts CASCADE`,
 		`DROP TABLE IF EXISTS deployments CASCADE`,
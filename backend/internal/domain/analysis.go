@@ -0,0 +1,35 @@
+package domain
+
+// RepositoryAnalysis is what the builder detects in a repository: the
+// framework and the defaults used when the project does not override them
+type RepositoryAnalysis struct {
+	Framework      string  `json:"framework"`
+	PackageManager string  `json:"package_manager,omitempty"`
+	InstallCommand string  `json:"install_command,omitempty"`
+	BuildCommand   string  `json:"build_command,omitempty"`
+	OutputDir      string  `json:"output_dir,omitempty"`
+	StartCommand   string  `json:"start_command,omitempty"`
+	Port           int     `json:"port"`
+	Confidence     float64 `json:"confidence"` // 0-1
+}
+
+type AnalyzeRepositoryRequest struct {
+	Ref           string  `json:"ref"`            // defaults to the production branch
+	RefType       RefType `json:"ref_type"`       // defaults to branch
+	RootDirectory *string `json:"root_directory"` // defaults to the project's
+}
+
+// AnalyzeEvent is sent as a request on BUILDER.analyze
+type AnalyzeEvent struct {
+	RepoURL       string              `json:"repo_url"`
+	Ref           string              `json:"ref"`
+	RefType       RefType             `json:"ref_type"`
+	RootDirectory string              `json:"root_directory,omitempty"`
+	Credential    *GitCredentialEvent `json:"credential,omitempty"`
+}
+
+// AnalyzeReply is the builder's answer to an AnalyzeEvent
+type AnalyzeReply struct {
+	Result *RepositoryAnalysis `json:"result,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// BuildLogLine is one line of build output as published by the builder on
+// BUILDS.logs.<deploymentID>
+type BuildLogLine struct {
+	DeploymentID string    `json:"deployment_id"`
+	Seq          int64     `json:"seq"`
+	Timestamp    time.Time `json:"timestamp"`
+	Step         string    `json:"step"`
+	Level        string    `json:"level"`
+	Message      string    `json:"message"`
+}
+
+// String renders the line the way it is shown in the log viewer
+func (l *BuildLogLine) String() string {
+	return fmt.Sprintf("%s [%s] %s", l.Timestamp.UTC().Format("2006-01-02T15:04:05.000Z"), l.Step, l.Message)
+}
+
+type BuildLogQuery struct {
+	Cursor int64
+	Limit  int
+	Level  string
+	Step   string
+	Search string
+}
+
+type BuildLogPage struct {
+	Lines      []*BuildLogLine `json:"lines"`
+	NextCursor int64           `json:"next_cursor"`
+	HasMore    bool            `json:"has_more"`
+}
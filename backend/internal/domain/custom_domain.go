@@ -0,0 +1,48 @@
+package domain
+
+import "time"
+
+type DomainStatus string
+
+const (
+	DomainPending  DomainStatus = "pending"
+	DomainVerified DomainStatus = "verified"
+)
+
+// TLS status values are written by the deployer
+const (
+	TLSNone         = "none"
+	TLSProvisioning = "provisioning"
+	TLSActive       = "active"
+)
+
+type CustomDomain struct {
+	ID                string       `json:"id"`
+	ProjectID         string       `json:"project_id"`
+	Hostname          string       `json:"hostname"`
+	VerificationToken string       `json:"-"`
+	Status            DomainStatus `json:"status"`
+	TLSStatus         string       `json:"tls_status"`
+	LastError         string       `json:"last_error,omitempty"`
+	VerifiedAt        *time.Time   `json:"verified_at,omitempty"`
+	CreatedAt         time.Time    `json:"created_at"`
+
+	// DNS records that prove ownership, either one is enough
+	Verification *DomainVerification `json:"verification,omitempty"`
+}
+
+type DomainVerification struct {
+	TXTName     string `json:"txt_name"`
+	TXTValue    string `json:"txt_value"`
+	CNAMETarget string `json:"cname_target"`
+}
+
+type CreateDomainRequest struct {
+	Hostname string `json:"hostname" validate:"required"`
+}
+
+// DomainsChangedEvent tells the deployer to reconcile a project's custom
+// domain ingress
+type DomainsChangedEvent struct {
+	ProjectID string `json:"project_id"`
+}
@@ -6,43 +6,161 @@ type DeploymentStatus string
 
 const (
 	StatusPending   DeploymentStatus = "pending"
+	StatusQueued    DeploymentStatus = "queued" // waiting for a free builder
 	StatusBuilding  DeploymentStatus = "building"
 	StatusDeploying DeploymentStatus = "deploying"
 	StatusReady     DeploymentStatus = "ready"
 	StatusError     DeploymentStatus = "error"
+	StatusCancelled DeploymentStatus = "cancelled"
+	StatusTimedOut  DeploymentStatus = "timed_out" // build ran past the plan's maximum build duration
+	StatusArchived  DeploymentStatus = "archived"  // cluster resources reclaimed, image kept
+)
+
+// deploymentTransitions is the deployment state machine: the statuses each
+// status may move to. Builds go pending → queued → building → deploying →
+// ready, redeploys of an existing image skip straight to deploying.
+var deploymentTransitions = map[DeploymentStatus][]DeploymentStatus{
+	StatusPending:   {StatusQueued, StatusDeploying, StatusCancelled, StatusError},
+	StatusQueued:    {StatusBuilding, StatusCancelled, StatusError},
+	StatusBuilding:  {StatusDeploying, StatusError, StatusCancelled, StatusTimedOut},
+	StatusDeploying: {StatusReady, StatusError},
+	StatusReady:     {StatusArchived},
+}
+
+// CanTransitionTo reports whether a deployment may move from s to next
+func (s DeploymentStatus) CanTransitionTo(next DeploymentStatus) bool {
+	for _, status := range deploymentTransitions[s] {
+		if status == next {
+			return true
+		}
+	}
+	return false
+}
+
+// DeploymentTransitions returns the state machine, which the migrations store
+// in deployment_transitions for the deployer
+func DeploymentTransitions() map[DeploymentStatus][]DeploymentStatus {
+	return deploymentTransitions
+}
+
+// InProgress reports whether the deployment is still on its way to ready
+func (s DeploymentStatus) InProgress() bool {
+	switch s {
+	case StatusPending, StatusQueued, StatusBuilding, StatusDeploying:
+		return true
+	}
+	return false
+}
+
+type DeploymentKind string
+
+const (
+	KindBuild    DeploymentKind = "build"
+	KindRollback DeploymentKind = "rollback"
+	KindPromote  DeploymentKind = "promote"
+)
+
+// RefType says how a deployment's ref is resolved in the repository
+type RefType string
+
+const (
+	RefBranch RefType = "branch"
+	RefTag    RefType = "tag"
+	RefCommit RefType = "commit"
 )
 
 type Deployment struct {
-	ID         string           `json:"id"`
-	ProjectID  string           `json:"project_id"`
-	Status     DeploymentStatus `json:"status"`
-	Subdomain  string           `json:"subdomain"`
-	ImageURL   string           `json:"image_url"`
-	CommitHash string           `json:"commit_hash"`
-	BuildLogs  string           `json:"build_logs"`
-	CreatedAt  time.Time        `json:"created_at"`
-	UpdatedAt  time.Time        `json:"updated_at"`
+	ID                 string           `json:"id"`
+	ProjectID          string           `json:"project_id"`
+	Kind               DeploymentKind   `json:"kind"`
+	SourceDeploymentID string           `json:"source_deployment_id,omitempty"`
+	Status             DeploymentStatus `json:"status"`
+	Subdomain          string           `json:"subdomain"`
+	ImageURL           string           `json:"image_url"`
+	Ref                string           `json:"ref,omitempty"`
+	RefType            RefType          `json:"ref_type,omitempty"`
+	CommitHash         string           `json:"commit_hash"` // resolved by the builder
+	CommitAuthor       string           `json:"commit_author,omitempty"`
+	CommitMessage      string           `json:"commit_message,omitempty"`
+	ErrorMessage       string           `json:"error_message,omitempty"`
+	Port               int              `json:"port,omitempty"` // exposed by the image
+	CreatedAt          time.Time        `json:"created_at"`
+	UpdatedAt          time.Time        `json:"updated_at"`
 }
 
 type TriggerDeployRequest struct {
-	ProjectID  string `json:"project_id" validate:"required"`
+	ProjectID string  `json:"project_id" validate:"required"`
+	Ref       string  `json:"ref"`      // defaults to the production branch
+	RefType   RefType `json:"ref_type"` // defaults to branch
+	NoCache   bool    `json:"no_cache"` // build from scratch, dropping the project's build cache
+
+	// Deprecated: use Ref. A full SHA is built as a commit, anything else as
+	// a branch.
 	CommitHash string `json:"commit_hash"`
 }
 
 type DeploymentEvent struct {
+	DeploymentID   string              `json:"deployment_id"`
+	ProjectID      string              `json:"project_id"`
+	RepoURL        string              `json:"repo_url"`
+	BuildCommand   string              `json:"build_command"`
+	OutputDir      string              `json:"output_dir"`
+	RootDirectory  string              `json:"root_directory,omitempty"`
+	InstallCommand string              `json:"install_command,omitempty"`
+	Framework      string              `json:"framework,omitempty"`
+	Ref            string              `json:"ref"`
+	RefType        RefType             `json:"ref_type"`
+	BuildEnv       map[string]string   `json:"build_env,omitempty"` // values encrypted with ENCRYPTION_KEY
+	Credential     *GitCredentialEvent `json:"credential,omitempty"`
+	NoCache        bool                `json:"no_cache,omitempty"`
+	BuildTimeout   int                 `json:"build_timeout"` // seconds, from the project's plan
+	Docker         DockerSettings      `json:"docker"`
+}
+
+// CancelBuildEvent asks the builders to stop a build, whether it is running or
+// still queued
+type CancelBuildEvent struct {
 	DeploymentID string `json:"deployment_id"`
-	ProjectID    string `json:"project_id"`
-	RepoURL      string `json:"repo_url"`
-	BuildCommand string `json:"build_command"`
-	OutputDir    string `json:"output_dir"`
-	CommitHash   string `json:"commit_hash"`
 }
 
 type BuildCompleteEvent struct {
 	DeploymentID string `json:"deployment_id"`
 	ImageURL     string `json:"image_url"`
 	Success      bool   `json:"success"`
-	Logs         string `json:"logs"`
+}
+
+// PromoteEvent asks the deployer to roll out an already built image
+type PromoteEvent struct {
+	DeploymentID       string `json:"deployment_id"`
+	ProjectID          string `json:"project_id"`
+	SourceDeploymentID string `json:"source_deployment_id"`
+	ImageURL           string `json:"image_url"`
+}
+
+// DeploymentAge is how long a deployment has been in its current status
+type DeploymentAge struct {
+	ID   string
+	Plan string // of its project
+	Age  time.Duration
+
+	// Until the deadline the deployer set for its rollout, including all its
+	// retries; nil without one
+	RolloutRemaining *time.Duration
+}
+
+// DeploymentStatusEvent is published on DEPLOYMENTS.status by every service
+// that moves a deployment to a new status
+type DeploymentStatusEvent struct {
+	DeploymentID string           `json:"deployment_id"`
+	Status       DeploymentStatus `json:"status"`
+	Timestamp    time.Time        `json:"timestamp"`
+}
+
+// ProjectTeardownEvent asks the deployer to remove every cluster object of a
+// deleted project
+type ProjectTeardownEvent struct {
+	ProjectID     string   `json:"project_id"`
+	DeploymentIDs []string `json:"deployment_ids"`
 }
 
 type DeployCompleteEvent struct {
@@ -0,0 +1,62 @@
+package domain
+
+import "testing"
+
+func TestCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from, to DeploymentStatus
+		want     bool
+	}{
+		// Builds
+		{StatusPending, StatusQueued, true},
+		{StatusQueued, StatusBuilding, true},
+		{StatusBuilding, StatusDeploying, true},
+		{StatusDeploying, StatusReady, true},
+		{StatusReady, StatusArchived, true},
+
+		// Rollbacks and promotions skip the build
+		{StatusPending, StatusDeploying, true},
+
+		// Failures and cancellations
+		{StatusPending, StatusError, true},
+		{StatusPending, StatusCancelled, true},
+		{StatusQueued, StatusCancelled, true},
+		{StatusQueued, StatusError, true},
+		{StatusBuilding, StatusCancelled, true},
+		{StatusBuilding, StatusTimedOut, true},
+		{StatusBuilding, StatusError, true},
+		{StatusDeploying, StatusError, true},
+
+		// A build that finishes after it was cancelled stays cancelled
+		{StatusCancelled, StatusDeploying, false},
+		{StatusCancelled, StatusError, false},
+
+		// Rollouts cannot be cancelled or time out as builds
+		{StatusDeploying, StatusCancelled, false},
+		{StatusDeploying, StatusTimedOut, false},
+
+		// No going back or skipping ahead
+		{StatusBuilding, StatusQueued, false},
+		{StatusQueued, StatusDeploying, false},
+		{StatusPending, StatusReady, false},
+		{StatusReady, StatusDeploying, false},
+		{StatusReady, StatusError, false},
+
+		// Final statuses
+		{StatusError, StatusQueued, false},
+		{StatusTimedOut, StatusError, false},
+		{StatusArchived, StatusReady, false},
+
+		// Same status
+		{StatusDeploying, StatusDeploying, false},
+
+		// Unknown status
+		{DeploymentStatus("unknown"), StatusQueued, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+			t.Errorf("%s.CanTransitionTo(%s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
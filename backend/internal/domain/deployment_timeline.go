@@ -0,0 +1,69 @@
+package domain
+
+import "time"
+
+// DeploymentTransition is a recorded status change of a deployment. The first
+// one of a deployment has no FromStatus.
+type DeploymentTransition struct {
+	ID           string           `json:"id"`
+	DeploymentID string           `json:"deployment_id"`
+	FromStatus   DeploymentStatus `json:"from_status,omitempty"`
+	ToStatus     DeploymentStatus `json:"to_status"`
+	Reason       string           `json:"reason,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+}
+
+// DeploymentPhase is the time a deployment spent in one in-progress status
+type DeploymentPhase struct {
+	Status     DeploymentStatus `json:"status"`
+	StartedAt  time.Time        `json:"started_at"`
+	EndedAt    *time.Time       `json:"ended_at"` // nil while the deployment is still in it
+	DurationMs int64            `json:"duration_ms"`
+}
+
+type DeploymentTimeline struct {
+	DeploymentID string                  `json:"deployment_id"`
+	Status       DeploymentStatus        `json:"status"`
+	Events       []*DeploymentTransition `json:"events"`
+	Phases       []*DeploymentPhase      `json:"phases"`
+	DurationMs   int64                   `json:"duration_ms"` // from creation until it left the last in-progress status
+}
+
+// NewDeploymentTimeline derives the phases of a deployment from its
+// transitions, which must be in order. Phases still running are measured
+// up to now.
+func NewDeploymentTimeline(deployment *Deployment, events []*DeploymentTransition, now time.Time) *DeploymentTimeline {
+	timeline := &DeploymentTimeline{
+		DeploymentID: deployment.ID,
+		Status:       deployment.Status,
+		Events:       events,
+		Phases:       []*DeploymentPhase{},
+	}
+	if timeline.Events == nil {
+		timeline.Events = []*DeploymentTransition{}
+	}
+	if len(events) == 0 {
+		return timeline
+	}
+
+	end := now
+	for i, event := range events {
+		if !event.ToStatus.InProgress() {
+			continue
+		}
+
+		phase := &DeploymentPhase{Status: event.ToStatus, StartedAt: event.CreatedAt}
+		phaseEnd := now
+		if i+1 < len(events) {
+			endedAt := events[i+1].CreatedAt
+			phase.EndedAt = &endedAt
+			phaseEnd = endedAt
+		}
+		phase.DurationMs = phaseEnd.Sub(phase.StartedAt).Milliseconds()
+		timeline.Phases = append(timeline.Phases, phase)
+		end = phaseEnd
+	}
+
+	timeline.DurationMs = end.Sub(events[0].CreatedAt).Milliseconds()
+	return timeline
+}
@@ -0,0 +1,145 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewDeploymentTimeline(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	event := func(from, to DeploymentStatus, seconds int) *DeploymentTransition {
+		return &DeploymentTransition{FromStatus: from, ToStatus: to, CreatedAt: at(seconds)}
+	}
+
+	type phase struct {
+		status     DeploymentStatus
+		ended      bool
+		durationMs int64
+	}
+
+	tests := []struct {
+		name       string
+		status     DeploymentStatus
+		events     []*DeploymentTransition
+		now        time.Time
+		phases     []phase
+		durationMs int64
+	}{
+		{
+			name:   "no events",
+			status: StatusPending,
+			now:    at(10),
+		},
+		{
+			name:   "ready build",
+			status: StatusReady,
+			events: []*DeploymentTransition{
+				event("", StatusPending, 0),
+				event(StatusPending, StatusQueued, 1),
+				event(StatusQueued, StatusBuilding, 3),
+				event(StatusBuilding, StatusDeploying, 63),
+				event(StatusDeploying, StatusReady, 93),
+			},
+			now: at(600),
+			phases: []phase{
+				{StatusPending, true, 1000},
+				{StatusQueued, true, 2000},
+				{StatusBuilding, true, 60000},
+				{StatusDeploying, true, 30000},
+			},
+			durationMs: 93000,
+		},
+		{
+			name:   "still building",
+			status: StatusBuilding,
+			events: []*DeploymentTransition{
+				event("", StatusPending, 0),
+				event(StatusPending, StatusQueued, 1),
+				event(StatusQueued, StatusBuilding, 5),
+			},
+			now: at(45),
+			phases: []phase{
+				{StatusPending, true, 1000},
+				{StatusQueued, true, 4000},
+				{StatusBuilding, false, 40000},
+			},
+			durationMs: 45000,
+		},
+		{
+			name:   "rollback straight to deploying",
+			status: StatusReady,
+			events: []*DeploymentTransition{
+				event("", StatusPending, 0),
+				event(StatusPending, StatusDeploying, 2),
+				event(StatusDeploying, StatusReady, 12),
+			},
+			now: at(100),
+			phases: []phase{
+				{StatusPending, true, 2000},
+				{StatusDeploying, true, 10000},
+			},
+			durationMs: 12000,
+		},
+		{
+			name:   "failed build stops the clock",
+			status: StatusError,
+			events: []*DeploymentTransition{
+				event("", StatusPending, 0),
+				event(StatusPending, StatusQueued, 1),
+				event(StatusQueued, StatusBuilding, 2),
+				event(StatusBuilding, StatusError, 20),
+			},
+			now: at(1000),
+			phases: []phase{
+				{StatusPending, true, 1000},
+				{StatusQueued, true, 1000},
+				{StatusBuilding, true, 18000},
+			},
+			durationMs: 20000,
+		},
+		{
+			name:   "archived after ready",
+			status: StatusArchived,
+			events: []*DeploymentTransition{
+				event("", StatusPending, 0),
+				event(StatusPending, StatusDeploying, 1),
+				event(StatusDeploying, StatusReady, 4),
+				event(StatusReady, StatusArchived, 500),
+			},
+			now: at(1000),
+			phases: []phase{
+				{StatusPending, true, 1000},
+				{StatusDeploying, true, 3000},
+			},
+			durationMs: 4000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := &Deployment{ID: "d1", Status: tt.status}
+			timeline := NewDeploymentTimeline(deployment, tt.events, tt.now)
+
+			if timeline.DeploymentID != "d1" || timeline.Status != tt.status {
+				t.Errorf("timeline of %s (%s), want d1 (%s)", timeline.DeploymentID, timeline.Status, tt.status)
+			}
+			if timeline.Events == nil {
+				t.Error("Events is nil, want an empty list")
+			}
+			if timeline.DurationMs != tt.durationMs {
+				t.Errorf("DurationMs = %d, want %d", timeline.DurationMs, tt.durationMs)
+			}
+			if len(timeline.Phases) != len(tt.phases) {
+				t.Fatalf("got %d phases, want %d", len(timeline.Phases), len(tt.phases))
+			}
+			for i, want := range tt.phases {
+				got := timeline.Phases[i]
+				if got.Status != want.status || (got.EndedAt != nil) != want.ended || got.DurationMs != want.durationMs {
+					t.Errorf("phase %d = %s ended=%v %dms, want %s ended=%v %dms",
+						i, got.Status, got.EndedAt != nil, got.DurationMs, want.status, want.ended, want.durationMs)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"regexp"
+)
+
+// DockerSettings is stored as JSONB on projects.docker. A project whose root
+// directory has a Dockerfile, or the one at Dockerfile, is built from it
+// instead of a generated image.
+type DockerSettings struct {
+	Dockerfile string            `json:"dockerfile,omitempty"` // relative to the root directory, "" = Dockerfile
+	BuildArgs  map[string]string `json:"build_args,omitempty"`
+	Target     string            `json:"target,omitempty"` // stage of a multi-stage build, "" = the last one
+}
+
+var (
+	buildArgNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	stageNamePattern    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+)
+
+func (d DockerSettings) Validate() error {
+	for name := range d.BuildArgs {
+		if !buildArgNamePattern.MatchString(name) {
+			return errors.New("build arg names must be letters, digits and underscores")
+		}
+	}
+	if d.Target != "" && !stageNamePattern.MatchString(d.Target) {
+		return errors.New("invalid docker target stage")
+	}
+	return nil
+}
+
+func (d DockerSettings) Value() (driver.Value, error) {
+	return json.Marshal(d)
+}
+
+func (d *DockerSettings) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d = DockerSettings{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, d)
+	case string:
+		return json.Unmarshal([]byte(v), d)
+	}
+	return errors.New("unsupported docker value")
+}
@@ -0,0 +1,31 @@
+package domain
+
+import "time"
+
+type EnvScope string
+
+const (
+	EnvScopeBuild   EnvScope = "build"
+	EnvScopeRuntime EnvScope = "runtime"
+)
+
+type EnvVar struct {
+	ID        string    `json:"id"`
+	ProjectID string    `json:"project_id"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	Scope     EnvScope  `json:"scope"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type CreateEnvVarRequest struct {
+	Key   string   `json:"key" validate:"required"`
+	Value string   `json:"value"`
+	Scope EnvScope `json:"scope"`
+}
+
+type UpdateEnvVarRequest struct {
+	Value *string  `json:"value"` // nil keeps the current value
+	Scope EnvScope `json:"scope"`
+}
@@ -0,0 +1,40 @@
+package domain
+
+import "time"
+
+type GitCredentialType string
+
+const (
+	GitCredentialDeployKey GitCredentialType = "deploy_key"
+	GitCredentialToken     GitCredentialType = "token"
+)
+
+// GitCredential lets the builder clone a private repository. A project has at
+// most one. Secret holds the encrypted private key or access token.
+type GitCredential struct {
+	ProjectID  string            `json:"project_id"`
+	Type       GitCredentialType `json:"type"`
+	Username   string            `json:"username,omitempty"`    // token credentials
+	PublicKey  string            `json:"public_key,omitempty"`  // deploy keys, add it to the repository
+	KnownHosts string            `json:"known_hosts,omitempty"` // deploy keys, trusted besides the built-in host keys
+	Secret     string            `json:"-"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+type SetKnownHostsRequest struct {
+	KnownHosts string `json:"known_hosts"`
+}
+
+type SetGitTokenRequest struct {
+	Username string `json:"username"`
+	Token    string `json:"token" validate:"required"`
+}
+
+// GitCredentialEvent is the credential as sent to the builder, the secret
+// still encrypted with the shared ENCRYPTION_KEY
+type GitCredentialEvent struct {
+	Type       GitCredentialType `json:"type"`
+	Username   string            `json:"username,omitempty"`
+	Secret     string            `json:"secret"`
+	KnownHosts string            `json:"known_hosts,omitempty"`
+}
@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+type ProbeType string
+
+const (
+	ProbeHTTP ProbeType = "http"
+	ProbeTCP  ProbeType = "tcp"
+)
+
+// Probe is rendered into a Kubernetes liveness, readiness or startup probe
+// against the app's container port. Zero values fall back to Kubernetes
+// defaults.
+type Probe struct {
+	Type                ProbeType `json:"type"`
+	Path                string    `json:"path,omitempty"`
+	InitialDelaySeconds int32     `json:"initial_delay_seconds,omitempty"`
+	PeriodSeconds       int32     `json:"period_seconds,omitempty"`
+	TimeoutSeconds      int32     `json:"timeout_seconds,omitempty"`
+	FailureThreshold    int32     `json:"failure_threshold,omitempty"`
+}
+
+// HealthChecks is stored as JSONB on projects.health_checks. Without a
+// readiness probe the deployer checks that the port accepts connections.
+type HealthChecks struct {
+	Liveness  *Probe `json:"liveness,omitempty"`
+	Readiness *Probe `json:"readiness,omitempty"`
+	Startup   *Probe `json:"startup,omitempty"`
+}
+
+func (h HealthChecks) Validate() error {
+	for _, probe := range []*Probe{h.Liveness, h.Readiness, h.Startup} {
+		if probe == nil {
+			continue
+		}
+		switch probe.Type {
+		case ProbeHTTP:
+			if probe.Path == "" || probe.Path[0] != '/' {
+				return errors.New("http probes need a path starting with /")
+			}
+		case ProbeTCP:
+		default:
+			return errors.New("probe type must be http or tcp")
+		}
+		if probe.InitialDelaySeconds < 0 || probe.PeriodSeconds < 0 || probe.TimeoutSeconds < 0 || probe.FailureThreshold < 0 {
+			return errors.New("probe timings must not be negative")
+		}
+	}
+	return nil
+}
+
+func (h HealthChecks) Value() (driver.Value, error) {
+	return json.Marshal(h)
+}
+
+func (h *HealthChecks) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*h = HealthChecks{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, h)
+	case string:
+		return json.Unmarshal([]byte(v), h)
+	}
+	return errors.New("unsupported health_checks value")
+}
@@ -0,0 +1,182 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Scaling is the effective resource and autoscaling configuration of a
+// project, stored as JSONB on projects.scaling and applied by the deployer.
+// CPU is in millicores, memory in MiB and targets in percent utilization
+// (0 disables that metric).
+type Scaling struct {
+	CPURequest    int  `json:"cpu_request"`
+	CPULimit      int  `json:"cpu_limit"`
+	MemoryRequest int  `json:"memory_request"`
+	MemoryLimit   int  `json:"memory_limit"`
+	MinReplicas   int  `json:"min_replicas"`
+	MaxReplicas   int  `json:"max_replicas"`
+	TargetCPU     int  `json:"target_cpu"`
+	TargetMemory  int  `json:"target_memory"`
+	ScaleToZero   bool `json:"scale_to_zero"`
+}
+
+func (s Scaling) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+func (s *Scaling) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*s = Scaling{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	}
+	return errors.New("unsupported scaling value")
+}
+
+// ScalingOverrides changes individual settings of a project's plan
+type ScalingOverrides struct {
+	CPURequest    *int  `json:"cpu_request"`
+	CPULimit      *int  `json:"cpu_limit"`
+	MemoryRequest *int  `json:"memory_request"`
+	MemoryLimit   *int  `json:"memory_limit"`
+	MinReplicas   *int  `json:"min_replicas"`
+	MaxReplicas   *int  `json:"max_replicas"`
+	TargetCPU     *int  `json:"target_cpu"`
+	TargetMemory  *int  `json:"target_memory"`
+	ScaleToZero   *bool `json:"scale_to_zero"`
+}
+
+// Apply copies every set override onto s
+func (o *ScalingOverrides) Apply(s *Scaling) {
+	if o == nil {
+		return
+	}
+	for _, f := range []struct {
+		src *int
+		dst *int
+	}{
+		{o.CPURequest, &s.CPURequest},
+		{o.CPULimit, &s.CPULimit},
+		{o.MemoryRequest, &s.MemoryRequest},
+		{o.MemoryLimit, &s.MemoryLimit},
+		{o.MinReplicas, &s.MinReplicas},
+		{o.MaxReplicas, &s.MaxReplicas},
+		{o.TargetCPU, &s.TargetCPU},
+		{o.TargetMemory, &s.TargetMemory},
+	} {
+		if f.src != nil {
+			*f.dst = *f.src
+		}
+	}
+	if o.ScaleToZero != nil {
+		s.ScaleToZero = *o.ScaleToZero
+	}
+}
+
+// Plan is a named set of scaling defaults and the ceilings overrides must
+// stay under. Plans double as billing tiers: an account can use its tier's
+// plan and every plan below it.
+type Plan struct {
+	Name             string  `json:"name"`
+	Rank             int     `json:"-"`
+	Defaults         Scaling `json:"defaults"`
+	MaxCPU           int     `json:"max_cpu"`
+	MaxMemory        int     `json:"max_memory"`
+	MaxReplicas      int     `json:"max_replicas"`
+	AllowScaleToZero bool    `json:"allow_scale_to_zero"`
+	MaxBuildMinutes  int     `json:"max_build_minutes"`
+}
+
+const DefaultPlan = "hobby"
+
+var Plans = []*Plan{
+	{
+		Name: "hobby",
+		Rank: 0,
+		Defaults: Scaling{
+			CPURequest: 100, CPULimit: 500,
+			MemoryRequest: 128, MemoryLimit: 512,
+			MinReplicas: 1, MaxReplicas: 2,
+			TargetCPU:   80,
+			ScaleToZero: true,
+		},
+		MaxCPU:           500,
+		MaxMemory:        512,
+		MaxReplicas:      2,
+		AllowScaleToZero: true,
+		MaxBuildMinutes:  10,
+	},
+	{
+		Name: "pro",
+		Rank: 1,
+		Defaults: Scaling{
+			CPURequest: 100, CPULimit: 500,
+			MemoryRequest: 128, MemoryLimit: 512,
+			MinReplicas: 2, MaxReplicas: 10,
+			TargetCPU: 80,
+		},
+		MaxCPU:           2000,
+		MaxMemory:        4096,
+		MaxReplicas:      10,
+		AllowScaleToZero: true,
+		MaxBuildMinutes:  30,
+	},
+	{
+		Name: "enterprise",
+		Rank: 2,
+		Defaults: Scaling{
+			CPURequest: 250, CPULimit: 1000,
+			MemoryRequest: 256, MemoryLimit: 1024,
+			MinReplicas: 2, MaxReplicas: 20,
+			TargetCPU: 70, TargetMemory: 80,
+		},
+		MaxCPU:          8000,
+		MaxMemory:       16384,
+		MaxReplicas:     50,
+		MaxBuildMinutes: 60,
+	},
+}
+
+// BuildTimeout is the longest a build may run on the plan
+func (p *Plan) BuildTimeout() time.Duration {
+	return time.Duration(p.MaxBuildMinutes) * time.Minute
+}
+
+func GetPlan(name string) *Plan {
+	for _, plan := range Plans {
+		if plan.Name == name {
+			return plan
+		}
+	}
+	return nil
+}
+
+// Validate checks that s is consistent and within the plan's ceilings
+func (p *Plan) Validate(s Scaling) error {
+	switch {
+	case s.CPURequest <= 0 || s.MemoryRequest <= 0:
+		return errors.New("cpu and memory requests must be positive")
+	case s.CPURequest > s.CPULimit || s.MemoryRequest > s.MemoryLimit:
+		return errors.New("requests must not exceed limits")
+	case s.CPULimit > p.MaxCPU || s.MemoryLimit > p.MaxMemory:
+		return errors.New("resource limits exceed the " + p.Name + " plan")
+	case s.MinReplicas < 1 || s.MinReplicas > s.MaxReplicas:
+		return errors.New("replicas must satisfy 1 <= min_replicas <= max_replicas")
+	case s.MaxReplicas > p.MaxReplicas:
+		return errors.New("max_replicas exceeds the " + p.Name + " plan")
+	case s.TargetCPU < 0 || s.TargetCPU > 100 || s.TargetMemory < 0 || s.TargetMemory > 100:
+		return errors.New("autoscaling targets must be between 0 and 100")
+	case s.MaxReplicas > s.MinReplicas && s.TargetCPU == 0 && s.TargetMemory == 0:
+		return errors.New("autoscaling needs a cpu or memory target")
+	case s.ScaleToZero && !p.AllowScaleToZero:
+		return errors.New("scale to zero is not available on the " + p.Name + " plan")
+	}
+	return nil
+}
@@ -3,26 +3,61 @@ package domain
 import "time"
 
 type Project struct {
-	ID           string    `json:"id"`
-	UserID       string    `json:"user_id"`
-	Name         string    `json:"name"`
-	RepoURL      string    `json:"repo_url"`
-	BuildCommand string    `json:"build_command"`
-	OutputDir    string    `json:"output_dir"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID                     string         `json:"id"`
+	UserID                 string         `json:"user_id"`
+	Name                   string         `json:"name"`
+	Slug                   string         `json:"slug"`
+	RepoURL                string         `json:"repo_url"`
+	BuildCommand           string         `json:"build_command"`
+	OutputDir              string         `json:"output_dir"`
+	ProductionBranch       string         `json:"production_branch"`
+	RootDirectory          string         `json:"root_directory"`  // subdirectory of a monorepo, "" = repository root
+	InstallCommand         string         `json:"install_command"` // "" = the framework's default
+	Framework              string         `json:"framework"`       // "" = detected by the builder
+	ProductionDeploymentID string         `json:"production_deployment_id,omitempty"`
+	WebhookSecret          string         `json:"webhook_secret"`
+	Port                   int            `json:"port"` // 0 = derived from the detected framework
+	HealthChecks           HealthChecks   `json:"health_checks"`
+	Docker                 DockerSettings `json:"docker"`
+	Plan                   string         `json:"plan"`
+	Scaling                Scaling        `json:"scaling"` // plan defaults with overrides applied
+	CreatedAt              time.Time      `json:"created_at"`
 }
 
 type CreateProjectRequest struct {
-	Name         string `json:"name" validate:"required"`
-	RepoURL      string `json:"repo_url" validate:"required,url"`
-	BuildCommand string `json:"build_command"`
-	OutputDir    string `json:"output_dir"`
+	Name             string            `json:"name" validate:"required"`
+	RepoURL          string            `json:"repo_url" validate:"required,url"`
+	BuildCommand     string            `json:"build_command"`
+	OutputDir        string            `json:"output_dir"`
+	ProductionBranch string            `json:"production_branch"`
+	RootDirectory    string            `json:"root_directory"`
+	InstallCommand   string            `json:"install_command"`
+	Framework        string            `json:"framework"`
+	Port             int               `json:"port"`
+	HealthChecks     *HealthChecks     `json:"health_checks"`
+	Docker           *DockerSettings   `json:"docker"`
+	Plan             string            `json:"plan"`
+	Scaling          *ScalingOverrides `json:"scaling"`
 }
 
 type UpdateProjectRequest struct {
-	Name         string `json:"name"`
-	RepoURL      string `json:"repo_url"`
-	BuildCommand string `json:"build_command"`
-	OutputDir    string `json:"output_dir"`
+	Name             string            `json:"name"`
+	RepoURL          string            `json:"repo_url"`
+	BuildCommand     *string           `json:"build_command"` // "" resets to detection
+	OutputDir        *string           `json:"output_dir"`    // "" resets to detection
+	ProductionBranch string            `json:"production_branch"`
+	RootDirectory    *string           `json:"root_directory"`  // "" resets to the repository root
+	InstallCommand   *string           `json:"install_command"` // "" resets to the framework default
+	Framework        *string           `json:"framework"`       // "" resets to detection
+	Port             *int              `json:"port"`            // 0 resets to the framework default
+	HealthChecks     *HealthChecks     `json:"health_checks"`
+	Docker           *DockerSettings   `json:"docker"`
+	Plan             string            `json:"plan"`
+	Scaling          *ScalingOverrides `json:"scaling"`
 }
 
+// Frameworks the builder can build, for overriding detection
+var Frameworks = []string{
+	"nextjs", "nuxtjs", "remix", "sveltekit", "astro", "vite", "nodejs", "bun",
+	"go", "rust", "django", "flask", "rails", "php", "static",
+}
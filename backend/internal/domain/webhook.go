@@ -0,0 +1,71 @@
+package domain
+
+import "time"
+
+const (
+	EventDeploymentStarted   = "deployment.started"
+	EventDeploymentBuilding  = "deployment.building"
+	EventDeploymentReady     = "deployment.ready"
+	EventDeploymentFailed    = "deployment.failed"
+	EventDeploymentCancelled = "deployment.cancelled"
+)
+
+// WebhookEvents lists every event an outbound webhook can subscribe to
+var WebhookEvents = []string{
+	EventDeploymentStarted,
+	EventDeploymentBuilding,
+	EventDeploymentReady,
+	EventDeploymentFailed,
+	EventDeploymentCancelled,
+}
+
+type Webhook struct {
+	ID        string    `json:"id"`
+	ProjectID string    `json:"project_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID           string    `json:"id"`
+	WebhookID    string    `json:"webhook_id"`
+	DeploymentID string    `json:"deployment_id"`
+	Event        string    `json:"event"`
+	Attempt      int       `json:"attempt"`
+	StatusCode   int       `json:"status_code"`
+	Success      bool      `json:"success"`
+	Error        string    `json:"error"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// WebhookJob is a delivery that has not succeeded yet. Its ID is the delivery
+// ID sent with every attempt.
+type WebhookJob struct {
+	ID           string
+	WebhookID    string
+	DeploymentID string
+	Event        string
+	Payload      []byte
+	Attempt      int // attempts made, including the one in progress
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url" validate:"required,url"`
+	Events []string `json:"events"`
+}
+
+type UpdateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// WebhookPayload is the JSON body POSTed to webhook endpoints
+type WebhookPayload struct {
+	Event      string      `json:"event"`
+	Timestamp  time.Time   `json:"timestamp"`
+	Deployment *Deployment `json:"deployment"`
+}
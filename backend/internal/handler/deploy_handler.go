@@ -1,6 +1,9 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
+
 	"github.com/dejavu/backend/internal/domain"
 	"github.com/dejavu/backend/internal/repository"
 	"github.com/dejavu/backend/internal/service"
@@ -11,17 +14,20 @@ import (
 )
 
 type DeployHandler struct {
-	service *service.DeploymentService
-	queue   *queue.Queue
+	service    *service.DeploymentService
+	logService *service.BuildLogService
+	queue      *queue.Queue
 }
 
 func NewDeployHandler(db *database.DB, nats *queue.Queue) *DeployHandler {
 	deployRepo := repository.NewDeploymentRepository(db)
 	projectRepo := repository.NewProjectRepository(db)
-	deployService := service.NewDeploymentService(deployRepo, projectRepo, nats)
+	deployService := service.NewDeploymentService(deployRepo, projectRepo, repository.NewGitCredentialRepository(db), repository.NewBillingRepository(db), newEnvVarService(db), nats)
+	logService := service.NewBuildLogService(repository.NewBuildLogRepository(db), deployRepo, projectRepo, nats)
 	return &DeployHandler{
-		service: deployService,
-		queue:   nats,
+		service:    deployService,
+		logService: logService,
+		queue:      nats,
 	}
 }
 
@@ -45,6 +51,76 @@ func (h *DeployHandler) Trigger(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusCreated).JSON(deployment)
 }
 
+// Analyze detects the framework and build defaults of a project's repository
+func (h *DeployHandler) Analyze(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+
+	var req domain.AnalyzeRepositoryRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request",
+			})
+		}
+	}
+
+	analysis, err := h.service.Analyze(userID, projectID, &req)
+	if err != nil {
+		status := fiber.StatusBadRequest
+		if errors.Is(err, service.ErrBuilderUnavailable) {
+			status = fiber.StatusServiceUnavailable
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(analysis)
+}
+
+func (h *DeployHandler) Rollback(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	deployID := c.Params("id")
+
+	deployment, err := h.service.Rollback(userID, deployID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(deployment)
+}
+
+func (h *DeployHandler) Cancel(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	deployID := c.Params("id")
+
+	deployment, err := h.service.Cancel(userID, deployID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(deployment)
+}
+
+func (h *DeployHandler) Promote(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	deployID := c.Params("id")
+
+	deployment, err := h.service.Promote(userID, deployID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(deployment)
+}
+
 func (h *DeployHandler) GetStatus(c *fiber.Ctx) error {
 	deployID := c.Params("id")
 
@@ -58,6 +134,21 @@ func (h *DeployHandler) GetStatus(c *fiber.Ctx) error {
 	return c.JSON(deployment)
 }
 
+// Timeline returns the status changes of a deployment with per-phase durations
+func (h *DeployHandler) Timeline(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	deployID := c.Params("id")
+
+	timeline, err := h.service.Timeline(userID, deployID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(timeline)
+}
+
 func (h *DeployHandler) StreamLogs(c *fiber.Ctx) error {
 	// Upgrade to WebSocket
 	if websocket.IsWebSocketUpgrade(c) {
@@ -67,7 +158,11 @@ func (h *DeployHandler) StreamLogs(c *fiber.Ctx) error {
 
 			// Subscribe to logs for this deployment
 			sub, err := h.queue.Subscribe("BUILDS.logs."+deployID, func(data []byte) {
-				ws.WriteMessage(websocket.TextMessage, data)
+				var line domain.BuildLogLine
+				if err := json.Unmarshal(data, &line); err != nil {
+					return
+				}
+				ws.WriteMessage(websocket.TextMessage, []byte(line.String()))
 			})
 			if err != nil {
 				ws.WriteMessage(websocket.TextMessage, []byte("Error subscribing to logs"))
@@ -85,8 +180,29 @@ func (h *DeployHandler) StreamLogs(c *fiber.Ctx) error {
 		})(c)
 	}
 
-	return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{
-		"error": "WebSocket upgrade required",
-	})
+	return h.ListLogs(c)
+}
+
+// ListLogs returns stored build log lines with cursor pagination and filters
+func (h *DeployHandler) ListLogs(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	deployID := c.Params("id")
+
+	query := &domain.BuildLogQuery{
+		Cursor: int64(c.QueryInt("cursor", 0)),
+		Limit:  c.QueryInt("limit", 0),
+		Level:  c.Query("level"),
+		Step:   c.Query("step"),
+		Search: c.Query("q"),
+	}
+
+	page, err := h.logService.List(deployID, userID, query)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(page)
 }
 
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"os"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/internal/repository"
+	"github.com/dejavu/backend/internal/service"
+	"github.com/dejavu/backend/pkg/database"
+	"github.com/dejavu/backend/pkg/queue"
+	"github.com/gofiber/fiber/v2"
+)
+
+type DomainHandler struct {
+	service *service.DomainService
+}
+
+func NewDomainHandler(db *database.DB, queue *queue.Queue) *DomainHandler {
+	baseDomain := os.Getenv("BASE_DOMAIN")
+	if baseDomain == "" {
+		baseDomain = "dejavu.local"
+	}
+
+	repo := repository.NewDomainRepository(db)
+	projectRepo := repository.NewProjectRepository(db)
+	domainService := service.NewDomainService(repo, projectRepo, queue, baseDomain, os.Getenv("DNS_RESOLVER"))
+	return &DomainHandler{service: domainService}
+}
+
+func (h *DomainHandler) List(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+
+	domains, err := h.service.List(projectID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(domains)
+}
+
+func (h *DomainHandler) Create(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+
+	var req domain.CreateDomainRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	d, err := h.service.Create(projectID, userID, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(d)
+}
+
+func (h *DomainHandler) Verify(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+	domainID := c.Params("domainID")
+
+	d, err := h.service.Verify(projectID, domainID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(d)
+}
+
+func (h *DomainHandler) Delete(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+	domainID := c.Params("domainID")
+
+	if err := h.service.Delete(projectID, domainID, userID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Domain deleted successfully",
+	})
+}
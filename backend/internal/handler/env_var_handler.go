@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"log"
+	"os"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/internal/repository"
+	"github.com/dejavu/backend/internal/service"
+	"github.com/dejavu/backend/pkg/database"
+	"github.com/dejavu/backend/pkg/secret"
+	"github.com/gofiber/fiber/v2"
+)
+
+type EnvVarHandler struct {
+	service *service.EnvVarService
+}
+
+func NewEnvVarHandler(db *database.DB) *EnvVarHandler {
+	return &EnvVarHandler{service: newEnvVarService(db)}
+}
+
+func (h *EnvVarHandler) List(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+
+	envVars, err := h.service.List(projectID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(envVars)
+}
+
+func (h *EnvVarHandler) Create(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+
+	var req domain.CreateEnvVarRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	envVar, err := h.service.Create(projectID, userID, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(envVar)
+}
+
+func (h *EnvVarHandler) Update(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+	envVarID := c.Params("envID")
+
+	var req domain.UpdateEnvVarRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	if err := h.service.Update(projectID, envVarID, userID, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Env var updated successfully",
+	})
+}
+
+func (h *EnvVarHandler) Delete(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+	envVarID := c.Params("envID")
+
+	if err := h.service.Delete(projectID, envVarID, userID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Env var deleted successfully",
+	})
+}
+
+func newEnvVarService(db *database.DB) *service.EnvVarService {
+	envRepo := repository.NewEnvVarRepository(db)
+	projectRepo := repository.NewProjectRepository(db)
+	return service.NewEnvVarService(envRepo, projectRepo, newCipher())
+}
+
+// newCipher encrypts project secrets with the key shared by the builder and
+// the deployer
+func newCipher() *secret.Cipher {
+	encryptionKey := os.Getenv("ENCRYPTION_KEY")
+	if encryptionKey == "" {
+		log.Fatal("ENCRYPTION_KEY is not set")
+	}
+
+	cipher, err := secret.NewCipher(encryptionKey)
+	if err != nil {
+		log.Fatal("Failed to initialize cipher:", err)
+	}
+	return cipher
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/internal/repository"
+	"github.com/dejavu/backend/internal/service"
+	"github.com/dejavu/backend/pkg/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+type GitCredentialHandler struct {
+	service *service.GitCredentialService
+}
+
+func NewGitCredentialHandler(db *database.DB) *GitCredentialHandler {
+	repo := repository.NewGitCredentialRepository(db)
+	projectRepo := repository.NewProjectRepository(db)
+	return &GitCredentialHandler{service: service.NewGitCredentialService(repo, projectRepo, newCipher())}
+}
+
+func (h *GitCredentialHandler) Get(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+
+	credential, err := h.service.Get(projectID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(credential)
+}
+
+func (h *GitCredentialHandler) GenerateDeployKey(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+
+	credential, err := h.service.GenerateDeployKey(projectID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(credential)
+}
+
+func (h *GitCredentialHandler) SetToken(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+
+	var req domain.SetGitTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	credential, err := h.service.SetToken(projectID, userID, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(credential)
+}
+
+func (h *GitCredentialHandler) SetKnownHosts(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+
+	var req domain.SetKnownHostsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	credential, err := h.service.SetKnownHosts(projectID, userID, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(credential)
+}
+
+func (h *GitCredentialHandler) Delete(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+
+	if err := h.service.Delete(projectID, userID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Credential deleted successfully",
+	})
+}
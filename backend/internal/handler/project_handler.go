@@ -5,6 +5,7 @@ import (
 	"github.com/dejavu/backend/internal/repository"
 	"github.com/dejavu/backend/internal/service"
 	"github.com/dejavu/backend/pkg/database"
+	"github.com/dejavu/backend/pkg/queue"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -12,9 +13,11 @@ type ProjectHandler struct {
 	service *service.ProjectService
 }
 
-func NewProjectHandler(db *database.DB) *ProjectHandler {
+func NewProjectHandler(db *database.DB, queue *queue.Queue) *ProjectHandler {
 	repo := repository.NewProjectRepository(db)
-	projectService := service.NewProjectService(repo)
+	deployRepo := repository.NewDeploymentRepository(db)
+	billingRepo := repository.NewBillingRepository(db)
+	projectService := service.NewProjectService(repo, deployRepo, billingRepo, queue)
 	return &ProjectHandler{service: projectService}
 }
 
@@ -102,3 +105,6 @@ func (h *ProjectHandler) Delete(c *fiber.Ctx) error {
 	})
 }
 
+func (h *ProjectHandler) ListPlans(c *fiber.Ctx) error {
+	return c.JSON(domain.Plans)
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/internal/repository"
+	"github.com/dejavu/backend/internal/service"
+	"github.com/dejavu/backend/pkg/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+type WebhookEndpointHandler struct {
+	service *service.WebhookEndpointService
+}
+
+func NewWebhookEndpointHandler(db *database.DB) *WebhookEndpointHandler {
+	repo := repository.NewWebhookRepository(db)
+	projectRepo := repository.NewProjectRepository(db)
+	endpointService := service.NewWebhookEndpointService(repo, projectRepo)
+	return &WebhookEndpointHandler{service: endpointService}
+}
+
+func (h *WebhookEndpointHandler) List(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+
+	webhooks, err := h.service.List(projectID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(webhooks)
+}
+
+func (h *WebhookEndpointHandler) Create(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+
+	var req domain.CreateWebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	webhook, err := h.service.Create(projectID, userID, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(webhook)
+}
+
+func (h *WebhookEndpointHandler) Update(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+	webhookID := c.Params("webhookID")
+
+	var req domain.UpdateWebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	if err := h.service.Update(projectID, webhookID, userID, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Webhook updated successfully",
+	})
+}
+
+func (h *WebhookEndpointHandler) Delete(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+	webhookID := c.Params("webhookID")
+
+	if err := h.service.Delete(projectID, webhookID, userID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Webhook deleted successfully",
+	})
+}
+
+func (h *WebhookEndpointHandler) ListDeliveries(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	projectID := c.Params("id")
+	webhookID := c.Params("webhookID")
+
+	deliveries, err := h.service.ListDeliveries(projectID, webhookID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(deliveries)
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/dejavu/backend/internal/repository"
+	"github.com/dejavu/backend/internal/service"
+	"github.com/dejavu/backend/pkg/database"
+	"github.com/dejavu/backend/pkg/queue"
+	"github.com/gofiber/fiber/v2"
+)
+
+type WebhookHandler struct {
+	service *service.WebhookService
+}
+
+func NewWebhookHandler(db *database.DB, nats *queue.Queue) *WebhookHandler {
+	deployRepo := repository.NewDeploymentRepository(db)
+	projectRepo := repository.NewProjectRepository(db)
+	deployService := service.NewDeploymentService(deployRepo, projectRepo, repository.NewGitCredentialRepository(db), repository.NewBillingRepository(db), newEnvVarService(db), nats)
+	webhookService := service.NewWebhookService(projectRepo, deployService)
+	return &WebhookHandler{service: webhookService}
+}
+
+func (h *WebhookHandler) Push(c *fiber.Ctx) error {
+	provider := c.Params("provider")
+	projectID := c.Params("projectID")
+
+	header := func(key string) string {
+		return c.Get(key)
+	}
+
+	deployment, err := h.service.HandlePush(provider, projectID, header, c.Body())
+	if err != nil {
+		status := fiber.StatusBadRequest
+		switch {
+		case errors.Is(err, service.ErrInvalidSignature):
+			status = fiber.StatusUnauthorized
+		case errors.Is(err, service.ErrUnsupportedProvider):
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if deployment == nil {
+		return c.JSON(fiber.Map{
+			"message": "Event ignored",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(deployment)
+}
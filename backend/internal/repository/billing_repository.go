@@ -14,6 +14,7 @@ type BillingAccount struct {
 	ID      string
 	UserID  string
 	Credits float64
+	Tier    string
 }
 
 type UsageRecord struct {
@@ -34,16 +35,16 @@ func (r *BillingRepository) GetOrCreateAccount(userID string) (*BillingAccount,
 	
 	// Try to get existing account
 	err := r.db.QueryRow(
-		"SELECT id, user_id, credits FROM billing_accounts WHERE user_id = $1",
+		"SELECT id, user_id, credits, tier FROM billing_accounts WHERE user_id = $1",
 		userID,
-	).Scan(&account.ID, &account.UserID, &account.Credits)
+	).Scan(&account.ID, &account.UserID, &account.Credits, &account.Tier)
 
 	if err == sql.ErrNoRows {
 		// Create new account with initial credits
 		err = r.db.QueryRow(
-			"INSERT INTO billing_accounts (user_id, credits) VALUES ($1, $2) RETURNING id",
+			"INSERT INTO billing_accounts (user_id, credits) VALUES ($1, $2) RETURNING id, tier",
 			userID, 100.00, // $100 initial credits
-		).Scan(&account.ID)
+		).Scan(&account.ID, &account.Tier)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/pkg/database"
+)
+
+type BuildLogRepository struct {
+	db *database.DB
+}
+
+func NewBuildLogRepository(db *database.DB) *BuildLogRepository {
+	return &BuildLogRepository{db: db}
+}
+
+// Insert stores a line; redelivered lines are ignored
+func (r *BuildLogRepository) Insert(line *domain.BuildLogLine) error {
+	query := `
+		INSERT INTO build_log_lines (deployment_id, seq, timestamp, step, level, message)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (deployment_id, seq) DO NOTHING
+	`
+	_, err := r.db.Exec(
+		query,
+		line.DeploymentID,
+		line.Seq,
+		line.Timestamp,
+		line.Step,
+		line.Level,
+		line.Message,
+	)
+	return err
+}
+
+// List returns up to q.Limit lines after q.Cursor matching the filters, plus
+// one extra line when more results are available
+func (r *BuildLogRepository) List(deploymentID string, q *domain.BuildLogQuery) ([]*domain.BuildLogLine, error) {
+	conditions := []string{"deployment_id = $1", "seq > $2"}
+	args := []interface{}{deploymentID, q.Cursor}
+
+	if q.Level != "" {
+		args = append(args, q.Level)
+		conditions = append(conditions, fmt.Sprintf("level = $%d", len(args)))
+	}
+	if q.Step != "" {
+		args = append(args, q.Step)
+		conditions = append(conditions, fmt.Sprintf("step = $%d", len(args)))
+	}
+	if q.Search != "" {
+		args = append(args, "%"+escapeLike(q.Search)+"%")
+		conditions = append(conditions, fmt.Sprintf("message ILIKE $%d", len(args)))
+	}
+
+	args = append(args, q.Limit+1)
+	query := fmt.Sprintf(`
+		SELECT deployment_id, seq, timestamp, step, level, message
+		FROM build_log_lines
+		WHERE %s
+		ORDER BY seq ASC
+		LIMIT $%d
+	`, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []*domain.BuildLogLine
+	for rows.Next() {
+		line := &domain.BuildLogLine{}
+		if err := rows.Scan(
+			&line.DeploymentID,
+			&line.Seq,
+			&line.Timestamp,
+			&line.Step,
+			&line.Level,
+			&line.Message,
+		); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// DeleteBefore prunes log lines older than cutoff
+func (r *BuildLogRepository) DeleteBefore(cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM build_log_lines WHERE timestamp < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/dejavu/backend/internal/domain"
 	"github.com/dejavu/backend/pkg/database"
@@ -15,28 +16,60 @@ func NewDeploymentRepository(db *database.DB) *DeploymentRepository {
 	return &DeploymentRepository{db: db}
 }
 
+// Create inserts a deployment and records its initial status as its first
+// transition
 func (r *DeploymentRepository) Create(deployment *domain.Deployment) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO deployments (project_id, status, subdomain, commit_hash)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO deployments (project_id, kind, source_deployment_id, status, subdomain, image_url, ref, ref_type, commit_hash, commit_author, commit_message, port)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, NULLIF($6, ''), $7, $8, NULLIF($9, ''), $10, $11, $12)
 		RETURNING id, created_at, updated_at
 	`
-	return r.db.QueryRow(
+	err = tx.QueryRow(
 		query,
 		deployment.ProjectID,
+		deployment.Kind,
+		deployment.SourceDeploymentID,
 		deployment.Status,
 		deployment.Subdomain,
+		deployment.ImageURL,
+		deployment.Ref,
+		deployment.RefType,
 		deployment.CommitHash,
+		deployment.CommitAuthor,
+		deployment.CommitMessage,
+		deployment.Port,
 	).Scan(&deployment.ID, &deployment.CreatedAt, &deployment.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO deployment_events (deployment_id, from_status, to_status, created_at)
+		VALUES ($1, '', $2, $3)
+	`
+	if _, err := tx.Exec(query, deployment.ID, deployment.Status, deployment.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (r *DeploymentRepository) GetByID(id string) (*domain.Deployment, error) {
 	deployment := &domain.Deployment{}
 	query := `
-		SELECT id, project_id, status, subdomain, 
+		SELECT id, project_id, kind,
+		       COALESCE(source_deployment_id::text, '') as source_deployment_id,
+		       status, subdomain,
 		       COALESCE(image_url, '') as image_url, 
+		       ref, ref_type,
 		       COALESCE(commit_hash, '') as commit_hash, 
-		       COALESCE(build_logs, '') as build_logs, 
+		       commit_author, commit_message,
+		       error_message, port,
 		       created_at, updated_at
 		FROM deployments
 		WHERE id = $1
@@ -44,11 +77,18 @@ func (r *DeploymentRepository) GetByID(id string) (*domain.Deployment, error) {
 	err := r.db.QueryRow(query, id).Scan(
 		&deployment.ID,
 		&deployment.ProjectID,
+		&deployment.Kind,
+		&deployment.SourceDeploymentID,
 		&deployment.Status,
 		&deployment.Subdomain,
 		&deployment.ImageURL,
+		&deployment.Ref,
+		&deployment.RefType,
 		&deployment.CommitHash,
-		&deployment.BuildLogs,
+		&deployment.CommitAuthor,
+		&deployment.CommitMessage,
+		&deployment.ErrorMessage,
+		&deployment.Port,
 		&deployment.CreatedAt,
 		&deployment.UpdatedAt,
 	)
@@ -60,10 +100,14 @@ func (r *DeploymentRepository) GetByID(id string) (*domain.Deployment, error) {
 
 func (r *DeploymentRepository) ListByProjectID(projectID string) ([]*domain.Deployment, error) {
 	query := `
-		SELECT id, project_id, status, subdomain, 
+		SELECT id, project_id, kind,
+		       COALESCE(source_deployment_id::text, '') as source_deployment_id,
+		       status, subdomain,
 		       COALESCE(image_url, '') as image_url, 
+		       ref, ref_type,
 		       COALESCE(commit_hash, '') as commit_hash, 
-		       COALESCE(build_logs, '') as build_logs, 
+		       commit_author, commit_message,
+		       error_message, port,
 		       created_at, updated_at
 		FROM deployments
 		WHERE project_id = $1
@@ -81,11 +125,18 @@ func (r *DeploymentRepository) ListByProjectID(projectID string) ([]*domain.Depl
 		if err := rows.Scan(
 			&deployment.ID,
 			&deployment.ProjectID,
+			&deployment.Kind,
+			&deployment.SourceDeploymentID,
 			&deployment.Status,
 			&deployment.Subdomain,
 			&deployment.ImageURL,
+			&deployment.Ref,
+			&deployment.RefType,
 			&deployment.CommitHash,
-			&deployment.BuildLogs,
+			&deployment.CommitAuthor,
+			&deployment.CommitMessage,
+			&deployment.ErrorMessage,
+			&deployment.Port,
 			&deployment.CreatedAt,
 			&deployment.UpdatedAt,
 		); err != nil {
@@ -96,32 +147,147 @@ func (r *DeploymentRepository) ListByProjectID(projectID string) ([]*domain.Depl
 	return deployments, nil
 }
 
-func (r *DeploymentRepository) UpdateStatus(id string, status domain.DeploymentStatus) error {
+// Transition moves a deployment to status if the state machine allows it from
+// the current status, and records the move. It reports false, changing
+// nothing, when the move is not allowed, e.g. because the deployment was
+// cancelled in the meantime.
+func (r *DeploymentRepository) Transition(id string, status domain.DeploymentStatus, reason string) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var current domain.DeploymentStatus
+	err = tx.QueryRow("SELECT status FROM deployments WHERE id = $1 FOR UPDATE", id).Scan(&current)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !current.CanTransitionTo(status) {
+		return false, nil
+	}
+
+	// Reasons of failures also become the deployment's error message
 	query := `
 		UPDATE deployments
-		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		SET status = $1, updated_at = CURRENT_TIMESTAMP,
+		    error_message = CASE WHEN $1 IN ('error', 'timed_out') THEN $3 ELSE error_message END
 		WHERE id = $2
 	`
-	_, err := r.db.Exec(query, status, id)
-	return err
+	if _, err := tx.Exec(query, status, id, reason); err != nil {
+		return false, err
+	}
+
+	query = `
+		INSERT INTO deployment_events (deployment_id, from_status, to_status, reason)
+		VALUES ($1, $2, $3, $4)
+	`
+	if _, err := tx.Exec(query, id, current, status, reason); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
 }
 
-func (r *DeploymentRepository) UpdateImageURL(id, imageURL string) error {
+// SyncTransitions replaces the stored state machine with transitions
+func (r *DeploymentRepository) SyncTransitions(transitions map[domain.DeploymentStatus][]domain.DeploymentStatus) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM deployment_transitions"); err != nil {
+		return err
+	}
+	for from, statuses := range transitions {
+		for _, to := range statuses {
+			_, err := tx.Exec(
+				"INSERT INTO deployment_transitions (from_status, to_status) VALUES ($1, $2)",
+				from, to,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// ListTransitions returns the recorded status changes of a deployment, oldest
+// first
+func (r *DeploymentRepository) ListTransitions(deploymentID string) ([]*domain.DeploymentTransition, error) {
 	query := `
-		UPDATE deployments
-		SET image_url = $1, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $2
+		SELECT id, deployment_id, from_status, to_status, reason, created_at
+		FROM deployment_events
+		WHERE deployment_id = $1
+		ORDER BY seq ASC
 	`
-	_, err := r.db.Exec(query, imageURL, id)
-	return err
+	rows, err := r.db.Query(query, deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*domain.DeploymentTransition
+	for rows.Next() {
+		event := &domain.DeploymentTransition{}
+		if err := rows.Scan(
+			&event.ID,
+			&event.DeploymentID,
+			&event.FromStatus,
+			&event.ToStatus,
+			&event.Reason,
+			&event.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
 }
 
-func (r *DeploymentRepository) UpdateLogs(id, logs string) error {
+// ListAges returns how long each deployment in status has been in it
+func (r *DeploymentRepository) ListAges(status domain.DeploymentStatus) ([]*domain.DeploymentAge, error) {
+	query := `
+		SELECT d.id, p.plan, EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - d.updated_at),
+		       EXTRACT(EPOCH FROM d.rollout_deadline - CURRENT_TIMESTAMP)
+		FROM deployments d
+		JOIN projects p ON p.id = d.project_id
+		WHERE d.status = $1
+	`
+	rows, err := r.db.Query(query, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ages []*domain.DeploymentAge
+	for rows.Next() {
+		age := &domain.DeploymentAge{}
+		var seconds float64
+		var remaining sql.NullFloat64
+		if err := rows.Scan(&age.ID, &age.Plan, &seconds, &remaining); err != nil {
+			return nil, err
+		}
+		age.Age = time.Duration(seconds * float64(time.Second))
+		if remaining.Valid {
+			d := time.Duration(remaining.Float64 * float64(time.Second))
+			age.RolloutRemaining = &d
+		}
+		ages = append(ages, age)
+	}
+	return ages, rows.Err()
+}
+
+func (r *DeploymentRepository) UpdateImageURL(id, imageURL string) error {
 	query := `
 		UPDATE deployments
-		SET build_logs = $1, updated_at = CURRENT_TIMESTAMP
+		SET image_url = $1, updated_at = CURRENT_TIMESTAMP
 		WHERE id = $2
 	`
-	_, err := r.db.Exec(query, logs, id)
+	_, err := r.db.Exec(query, imageURL, id)
 	return err
 }
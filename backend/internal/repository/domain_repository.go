@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/pkg/database"
+	"github.com/lib/pq"
+)
+
+type DomainRepository struct {
+	db *database.DB
+}
+
+func NewDomainRepository(db *database.DB) *DomainRepository {
+	return &DomainRepository{db: db}
+}
+
+func (r *DomainRepository) Create(d *domain.CustomDomain) error {
+	query := `
+		INSERT INTO domains (project_id, hostname, verification_token, status, tls_status)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(
+		query,
+		d.ProjectID,
+		d.Hostname,
+		d.VerificationToken,
+		d.Status,
+		d.TLSStatus,
+	).Scan(&d.ID, &d.CreatedAt)
+}
+
+func (r *DomainRepository) GetByID(id string) (*domain.CustomDomain, error) {
+	d := &domain.CustomDomain{}
+	query := `
+		SELECT id, project_id, hostname, verification_token, status, tls_status, last_error, verified_at, created_at
+		FROM domains
+		WHERE id = $1
+	`
+	err := r.db.QueryRow(query, id).Scan(
+		&d.ID,
+		&d.ProjectID,
+		&d.Hostname,
+		&d.VerificationToken,
+		&d.Status,
+		&d.TLSStatus,
+		&d.LastError,
+		&d.VerifiedAt,
+		&d.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return d, err
+}
+
+// HostnameVerified reports whether a project has proven ownership of the
+// hostname. Unverified claims do not block anyone.
+func (r *DomainRepository) HostnameVerified(hostname string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM domains WHERE hostname = $1 AND status = $2)`, hostname, domain.DomainVerified).Scan(&exists)
+	return exists, err
+}
+
+// HostnameClaimed reports whether the project already added the hostname
+func (r *DomainRepository) HostnameClaimed(projectID, hostname string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM domains WHERE project_id = $1 AND hostname = $2)`, projectID, hostname).Scan(&exists)
+	return exists, err
+}
+
+func (r *DomainRepository) ListByProjectID(projectID string) ([]*domain.CustomDomain, error) {
+	query := `
+		SELECT id, project_id, hostname, verification_token, status, tls_status, last_error, verified_at, created_at
+		FROM domains
+		WHERE project_id = $1
+		ORDER BY created_at ASC
+	`
+	rows, err := r.db.Query(query, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []*domain.CustomDomain
+	for rows.Next() {
+		d := &domain.CustomDomain{}
+		if err := rows.Scan(
+			&d.ID,
+			&d.ProjectID,
+			&d.Hostname,
+			&d.VerificationToken,
+			&d.Status,
+			&d.TLSStatus,
+			&d.LastError,
+			&d.VerifiedAt,
+			&d.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+// MarkVerified gives the hostname to the domain's project. It reports false
+// when another project verified the hostname first.
+func (r *DomainRepository) MarkVerified(id string) (bool, error) {
+	query := `
+		UPDATE domains
+		SET status = $1, last_error = '', verified_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+	_, err := r.db.Exec(query, domain.DomainVerified, id)
+	// Only one verified domain per hostname
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *DomainRepository) SetLastError(id, message string) error {
+	_, err := r.db.Exec(`UPDATE domains SET last_error = $1 WHERE id = $2`, message, id)
+	return err
+}
+
+func (r *DomainRepository) Delete(id, projectID string) error {
+	query := `DELETE FROM domains WHERE id = $1 AND project_id = $2`
+	result, err := r.db.Exec(query, id, projectID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/pkg/database"
+)
+
+// EnvVarRepository stores env vars with their values already encrypted
+type EnvVarRepository struct {
+	db *database.DB
+}
+
+func NewEnvVarRepository(db *database.DB) *EnvVarRepository {
+	return &EnvVarRepository{db: db}
+}
+
+func (r *EnvVarRepository) Create(envVar *domain.EnvVar) error {
+	query := `
+		INSERT INTO env_vars (project_id, key, value, scope)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at
+	`
+	return r.db.QueryRow(
+		query,
+		envVar.ProjectID,
+		envVar.Key,
+		envVar.Value,
+		envVar.Scope,
+	).Scan(&envVar.ID, &envVar.CreatedAt, &envVar.UpdatedAt)
+}
+
+func (r *EnvVarRepository) GetByID(id string) (*domain.EnvVar, error) {
+	envVar := &domain.EnvVar{}
+	query := `
+		SELECT id, project_id, key, value, scope, created_at, updated_at
+		FROM env_vars
+		WHERE id = $1
+	`
+	err := r.db.QueryRow(query, id).Scan(
+		&envVar.ID,
+		&envVar.ProjectID,
+		&envVar.Key,
+		&envVar.Value,
+		&envVar.Scope,
+		&envVar.CreatedAt,
+		&envVar.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return envVar, err
+}
+
+func (r *EnvVarRepository) ListByProjectID(projectID string) ([]*domain.EnvVar, error) {
+	query := `
+		SELECT id, project_id, key, value, scope, created_at, updated_at
+		FROM env_vars
+		WHERE project_id = $1
+		ORDER BY key ASC
+	`
+	rows, err := r.db.Query(query, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var envVars []*domain.EnvVar
+	for rows.Next() {
+		envVar := &domain.EnvVar{}
+		if err := rows.Scan(
+			&envVar.ID,
+			&envVar.ProjectID,
+			&envVar.Key,
+			&envVar.Value,
+			&envVar.Scope,
+			&envVar.CreatedAt,
+			&envVar.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		envVars = append(envVars, envVar)
+	}
+	return envVars, nil
+}
+
+func (r *EnvVarRepository) Update(envVar *domain.EnvVar) error {
+	query := `
+		UPDATE env_vars
+		SET value = $1, scope = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND project_id = $4
+	`
+	result, err := r.db.Exec(query, envVar.Value, envVar.Scope, envVar.ID, envVar.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *EnvVarRepository) Delete(id, projectID string) error {
+	query := `DELETE FROM env_vars WHERE id = $1 AND project_id = $2`
+	result, err := r.db.Exec(query, id, projectID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/pkg/database"
+)
+
+type GitCredentialRepository struct {
+	db *database.DB
+}
+
+func NewGitCredentialRepository(db *database.DB) *GitCredentialRepository {
+	return &GitCredentialRepository{db: db}
+}
+
+// Save replaces the credential of a project
+func (r *GitCredentialRepository) Save(c *domain.GitCredential) error {
+	query := `
+		INSERT INTO git_credentials (project_id, type, username, public_key, secret)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (project_id) DO UPDATE
+		SET type = EXCLUDED.type, username = EXCLUDED.username, public_key = EXCLUDED.public_key,
+		    secret = EXCLUDED.secret, created_at = CURRENT_TIMESTAMP
+		RETURNING created_at
+	`
+	return r.db.QueryRow(
+		query,
+		c.ProjectID,
+		c.Type,
+		c.Username,
+		c.PublicKey,
+		c.Secret,
+	).Scan(&c.CreatedAt)
+}
+
+func (r *GitCredentialRepository) GetByProjectID(projectID string) (*domain.GitCredential, error) {
+	c := &domain.GitCredential{}
+	query := `
+		SELECT project_id, type, username, public_key, known_hosts, secret, created_at
+		FROM git_credentials
+		WHERE project_id = $1
+	`
+	err := r.db.QueryRow(query, projectID).Scan(
+		&c.ProjectID,
+		&c.Type,
+		&c.Username,
+		&c.PublicKey,
+		&c.KnownHosts,
+		&c.Secret,
+		&c.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return c, err
+}
+
+// SetKnownHosts replaces the extra host keys of a project's credential
+func (r *GitCredentialRepository) SetKnownHosts(projectID, knownHosts string) error {
+	_, err := r.db.Exec(`UPDATE git_credentials SET known_hosts = $2 WHERE project_id = $1`, projectID, knownHosts)
+	return err
+}
+
+func (r *GitCredentialRepository) Delete(projectID string) error {
+	_, err := r.db.Exec(`DELETE FROM git_credentials WHERE project_id = $1`, projectID)
+	return err
+}
@@ -17,24 +17,38 @@ func NewProjectRepository(db *database.DB) *ProjectRepository {
 
 func (r *ProjectRepository) Create(project *domain.Project) error {
 	query := `
-		INSERT INTO projects (user_id, name, repo_url, build_command, output_dir)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, created_at
+		INSERT INTO projects (user_id, name, slug, repo_url, build_command, output_dir, production_branch,
+		                      root_directory, install_command, framework, port, health_checks, docker, plan, scaling)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id, webhook_secret, created_at
 	`
 	return r.db.QueryRow(
 		query,
 		project.UserID,
 		project.Name,
+		project.Slug,
 		project.RepoURL,
 		project.BuildCommand,
 		project.OutputDir,
-	).Scan(&project.ID, &project.CreatedAt)
+		project.ProductionBranch,
+		project.RootDirectory,
+		project.InstallCommand,
+		project.Framework,
+		project.Port,
+		project.HealthChecks,
+		project.Docker,
+		project.Plan,
+		project.Scaling,
+	).Scan(&project.ID, &project.WebhookSecret, &project.CreatedAt)
 }
 
 func (r *ProjectRepository) GetByID(id string) (*domain.Project, error) {
 	project := &domain.Project{}
 	query := `
-		SELECT id, user_id, name, repo_url, build_command, output_dir, created_at
+		SELECT id, user_id, name, slug, repo_url, build_command, output_dir,
+		       production_branch, COALESCE(production_deployment_id::text, '') as production_deployment_id,
+		       root_directory, install_command, framework,
+		       webhook_secret, port, health_checks, docker, plan, scaling, created_at
 		FROM projects
 		WHERE id = $1
 	`
@@ -42,9 +56,21 @@ func (r *ProjectRepository) GetByID(id string) (*domain.Project, error) {
 		&project.ID,
 		&project.UserID,
 		&project.Name,
+		&project.Slug,
 		&project.RepoURL,
 		&project.BuildCommand,
 		&project.OutputDir,
+		&project.ProductionBranch,
+		&project.ProductionDeploymentID,
+		&project.RootDirectory,
+		&project.InstallCommand,
+		&project.Framework,
+		&project.WebhookSecret,
+		&project.Port,
+		&project.HealthChecks,
+		&project.Docker,
+		&project.Plan,
+		&project.Scaling,
 		&project.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
@@ -53,9 +79,18 @@ func (r *ProjectRepository) GetByID(id string) (*domain.Project, error) {
 	return project, err
 }
 
+func (r *ProjectRepository) SlugExists(slug string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM projects WHERE slug = $1)`, slug).Scan(&exists)
+	return exists, err
+}
+
 func (r *ProjectRepository) ListByUserID(userID string) ([]*domain.Project, error) {
 	query := `
-		SELECT id, user_id, name, repo_url, build_command, output_dir, created_at
+		SELECT id, user_id, name, slug, repo_url, build_command, output_dir,
+		       production_branch, COALESCE(production_deployment_id::text, '') as production_deployment_id,
+		       root_directory, install_command, framework,
+		       webhook_secret, port, health_checks, docker, plan, scaling, created_at
 		FROM projects
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -73,9 +108,21 @@ func (r *ProjectRepository) ListByUserID(userID string) ([]*domain.Project, erro
 			&project.ID,
 			&project.UserID,
 			&project.Name,
+			&project.Slug,
 			&project.RepoURL,
 			&project.BuildCommand,
 			&project.OutputDir,
+			&project.ProductionBranch,
+			&project.ProductionDeploymentID,
+			&project.RootDirectory,
+			&project.InstallCommand,
+			&project.Framework,
+			&project.WebhookSecret,
+			&project.Port,
+			&project.HealthChecks,
+			&project.Docker,
+			&project.Plan,
+			&project.Scaling,
 			&project.CreatedAt,
 		); err != nil {
 			return nil, err
@@ -88,8 +135,10 @@ func (r *ProjectRepository) ListByUserID(userID string) ([]*domain.Project, erro
 func (r *ProjectRepository) Update(project *domain.Project) error {
 	query := `
 		UPDATE projects
-		SET name = $1, repo_url = $2, build_command = $3, output_dir = $4
-		WHERE id = $5 AND user_id = $6
+		SET name = $1, repo_url = $2, build_command = $3, output_dir = $4, production_branch = $5,
+		    root_directory = $6, install_command = $7, framework = $8,
+		    port = $9, health_checks = $10, docker = $11, plan = $12, scaling = $13
+		WHERE id = $14 AND user_id = $15
 	`
 	result, err := r.db.Exec(
 		query,
@@ -97,6 +146,15 @@ func (r *ProjectRepository) Update(project *domain.Project) error {
 		project.RepoURL,
 		project.BuildCommand,
 		project.OutputDir,
+		project.ProductionBranch,
+		project.RootDirectory,
+		project.InstallCommand,
+		project.Framework,
+		project.Port,
+		project.HealthChecks,
+		project.Docker,
+		project.Plan,
+		project.Scaling,
 		project.ID,
 		project.UserID,
 	)
@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/pkg/database"
+	"github.com/lib/pq"
+)
+
+type WebhookRepository struct {
+	db *database.DB
+}
+
+func NewWebhookRepository(db *database.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (r *WebhookRepository) Create(webhook *domain.Webhook) error {
+	query := `
+		INSERT INTO webhooks (project_id, url, secret, events, active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(
+		query,
+		webhook.ProjectID,
+		webhook.URL,
+		webhook.Secret,
+		pq.Array(webhook.Events),
+		webhook.Active,
+	).Scan(&webhook.ID, &webhook.CreatedAt)
+}
+
+func (r *WebhookRepository) GetByID(id string) (*domain.Webhook, error) {
+	webhook := &domain.Webhook{}
+	query := `
+		SELECT id, project_id, url, secret, events, active, created_at
+		FROM webhooks
+		WHERE id = $1
+	`
+	err := r.db.QueryRow(query, id).Scan(
+		&webhook.ID,
+		&webhook.ProjectID,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.Events),
+		&webhook.Active,
+		&webhook.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return webhook, err
+}
+
+func (r *WebhookRepository) ListByProjectID(projectID string) ([]*domain.Webhook, error) {
+	query := `
+		SELECT id, project_id, url, secret, events, active, created_at
+		FROM webhooks
+		WHERE project_id = $1
+		ORDER BY created_at DESC
+	`
+	return r.list(query, projectID)
+}
+
+// ListSubscribed returns the active webhooks of a project that listen to event
+func (r *WebhookRepository) ListSubscribed(projectID, event string) ([]*domain.Webhook, error) {
+	query := `
+		SELECT id, project_id, url, secret, events, active, created_at
+		FROM webhooks
+		WHERE project_id = $1 AND active = TRUE AND $2 = ANY(events)
+	`
+	return r.list(query, projectID, event)
+}
+
+func (r *WebhookRepository) list(query string, args ...interface{}) ([]*domain.Webhook, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []*domain.Webhook
+	for rows.Next() {
+		webhook := &domain.Webhook{}
+		if err := rows.Scan(
+			&webhook.ID,
+			&webhook.ProjectID,
+			&webhook.URL,
+			&webhook.Secret,
+			pq.Array(&webhook.Events),
+			&webhook.Active,
+			&webhook.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+func (r *WebhookRepository) Update(webhook *domain.Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $1, events = $2, active = $3
+		WHERE id = $4 AND project_id = $5
+	`
+	result, err := r.db.Exec(
+		query,
+		webhook.URL,
+		pq.Array(webhook.Events),
+		webhook.Active,
+		webhook.ID,
+		webhook.ProjectID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *WebhookRepository) Delete(id, projectID string) error {
+	query := `DELETE FROM webhooks WHERE id = $1 AND project_id = $2`
+	result, err := r.db.Exec(query, id, projectID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *WebhookRepository) RecordDelivery(delivery *domain.WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, deployment_id, event, attempt, status_code, success, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(
+		query,
+		delivery.WebhookID,
+		delivery.DeploymentID,
+		delivery.Event,
+		delivery.Attempt,
+		delivery.StatusCode,
+		delivery.Success,
+		delivery.Error,
+	).Scan(&delivery.ID, &delivery.CreatedAt)
+}
+
+// EnqueueJob schedules a delivery for immediate sending
+func (r *WebhookRepository) EnqueueJob(job *domain.WebhookJob) error {
+	query := `
+		INSERT INTO webhook_jobs (webhook_id, deployment_id, event, payload)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	return r.db.QueryRow(query, job.WebhookID, job.DeploymentID, job.Event, string(job.Payload)).Scan(&job.ID)
+}
+
+// ClaimDueJobs takes up to limit jobs that are due and counts an attempt for
+// each. They are leased until lease has passed, after which another
+// dispatcher picks them up again, e.g. when this one died mid-delivery.
+func (r *WebhookRepository) ClaimDueJobs(limit int, lease time.Duration) ([]*domain.WebhookJob, error) {
+	query := `
+		UPDATE webhook_jobs
+		SET attempt = attempt + 1, next_attempt_at = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM webhook_jobs
+			WHERE next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, webhook_id, deployment_id, event, payload, attempt
+	`
+	rows, err := r.db.Query(query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*domain.WebhookJob
+	for rows.Next() {
+		job := &domain.WebhookJob{}
+		var payload string
+		if err := rows.Scan(&job.ID, &job.WebhookID, &job.DeploymentID, &job.Event, &payload, &job.Attempt); err != nil {
+			return nil, err
+		}
+		job.Payload = []byte(payload)
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// RescheduleJob makes the next attempt of a job due after delay
+func (r *WebhookRepository) RescheduleJob(id string, delay time.Duration) error {
+	query := `
+		UPDATE webhook_jobs
+		SET next_attempt_at = CURRENT_TIMESTAMP + $1 * INTERVAL '1 second'
+		WHERE id = $2
+	`
+	_, err := r.db.Exec(query, delay.Seconds(), id)
+	return err
+}
+
+// DeleteJob removes a job that succeeded or was given up
+func (r *WebhookRepository) DeleteJob(id string) error {
+	_, err := r.db.Exec("DELETE FROM webhook_jobs WHERE id = $1", id)
+	return err
+}
+
+func (r *WebhookRepository) ListDeliveries(webhookID string, limit int) ([]*domain.WebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, deployment_id, event, attempt, status_code, success, error, created_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+	rows, err := r.db.Query(query, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*domain.WebhookDelivery
+	for rows.Next() {
+		delivery := &domain.WebhookDelivery{}
+		if err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.DeploymentID,
+			&delivery.Event,
+			&delivery.Attempt,
+			&delivery.StatusCode,
+			&delivery.Success,
+			&delivery.Error,
+			&delivery.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/internal/repository"
+	"github.com/dejavu/backend/pkg/queue"
+)
+
+const (
+	defaultLogPageSize = 100
+	maxLogPageSize     = 1000
+)
+
+type BuildLogService struct {
+	repo        *repository.BuildLogRepository
+	deployRepo  *repository.DeploymentRepository
+	projectRepo *repository.ProjectRepository
+	queue       *queue.Queue
+}
+
+func NewBuildLogService(
+	repo *repository.BuildLogRepository,
+	deployRepo *repository.DeploymentRepository,
+	projectRepo *repository.ProjectRepository,
+	queue *queue.Queue,
+) *BuildLogService {
+	return &BuildLogService{
+		repo:        repo,
+		deployRepo:  deployRepo,
+		projectRepo: projectRepo,
+		queue:       queue,
+	}
+}
+
+// StartCollector persists every line the builder streams
+func (s *BuildLogService) StartCollector() error {
+	_, err := s.queue.QueueSubscribe("BUILDS.logs.*", "log-collector", func(data []byte) {
+		var line domain.BuildLogLine
+		if err := json.Unmarshal(data, &line); err != nil {
+			log.Printf("Error parsing log line: %v", err)
+			return
+		}
+		if err := s.repo.Insert(&line); err != nil {
+			log.Printf("Error storing log line: %v", err)
+		}
+	})
+	return err
+}
+
+// StartRetention prunes logs older than retention once an hour
+func (s *BuildLogService) StartRetention(retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			deleted, err := s.repo.DeleteBefore(time.Now().Add(-retention))
+			if err != nil {
+				log.Printf("Error pruning build logs: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("Pruned %d build log lines", deleted)
+			}
+		}
+	}()
+}
+
+func (s *BuildLogService) List(deploymentID, userID string, q *domain.BuildLogQuery) (*domain.BuildLogPage, error) {
+	deployment, err := s.deployRepo.GetByID(deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	if deployment == nil {
+		return nil, errors.New("deployment not found")
+	}
+
+	project, err := s.projectRepo.GetByID(deployment.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil || project.UserID != userID {
+		return nil, errors.New("unauthorized")
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = defaultLogPageSize
+	}
+	if q.Limit > maxLogPageSize {
+		q.Limit = maxLogPageSize
+	}
+
+	lines, err := s.repo.List(deploymentID, q)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &domain.BuildLogPage{
+		Lines:      lines,
+		NextCursor: q.Cursor,
+	}
+	if len(lines) > q.Limit {
+		page.Lines = lines[:q.Limit]
+		page.HasMore = true
+	}
+	if len(page.Lines) > 0 {
+		page.NextCursor = page.Lines[len(page.Lines)-1].Seq
+	}
+	if page.Lines == nil {
+		page.Lines = []*domain.BuildLogLine{}
+	}
+
+	return page, nil
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/internal/repository"
+	"github.com/dejavu/backend/pkg/queue"
+)
+
+const (
+	// A build is delivered to builders this often before JetStream gives up
+	// on it, each attempt taking up to the plan's build timeout plus the time
+	// until it is redelivered
+	buildAttempts     = 3
+	buildAttemptSlack = 2 * time.Minute
+
+	// Rollouts past the deadline the deployer set from its rollout timeout
+	// and retries are failed. This limit is for those without one, e.g. from
+	// before deployers set it.
+	maxDeployingAge = time.Hour
+
+	reaperInterval = time.Minute
+)
+
+// DeploymentReaper fails deployments whose builder or deployer gave up on
+// them without saying so, e.g. a builder that crashed on every attempt, which
+// would otherwise stay building forever
+type DeploymentReaper struct {
+	deployRepo *repository.DeploymentRepository
+	queue      *queue.Queue
+}
+
+func NewDeploymentReaper(deployRepo *repository.DeploymentRepository, queue *queue.Queue) *DeploymentReaper {
+	return &DeploymentReaper{deployRepo: deployRepo, queue: queue}
+}
+
+// Start checks for stale deployments once a minute
+func (r *DeploymentReaper) Start() {
+	go func() {
+		ticker := time.NewTicker(reaperInterval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			r.reap(domain.StatusBuilding, func(age *domain.DeploymentAge) string {
+				limit := buildAttempts * (buildPlan(age.Plan).BuildTimeout() + buildAttemptSlack)
+				if age.Age <= limit {
+					return ""
+				}
+				return fmt.Sprintf("still building after %s, its builders gave up", limit.Round(time.Minute))
+			})
+			r.reap(domain.StatusDeploying, func(age *domain.DeploymentAge) string {
+				switch {
+				case age.RolloutRemaining != nil && *age.RolloutRemaining < 0:
+					return "still deploying after the deployer's last attempt, it gave up"
+				case age.RolloutRemaining == nil && age.Age > maxDeployingAge:
+					return fmt.Sprintf("still deploying after %s, its deployer gave up", maxDeployingAge.Round(time.Minute))
+				}
+				return ""
+			})
+		}
+	}()
+}
+
+// reap fails the deployments in status that stale returns a reason for
+func (r *DeploymentReaper) reap(status domain.DeploymentStatus, stale func(*domain.DeploymentAge) string) {
+	ages, err := r.deployRepo.ListAges(status)
+	if err != nil {
+		log.Printf("Error listing %s deployments: %v", status, err)
+		return
+	}
+
+	for _, age := range ages {
+		reason := stale(age)
+		if reason == "" {
+			continue
+		}
+
+		moved, err := r.deployRepo.Transition(age.ID, domain.StatusError, reason)
+		if err != nil {
+			log.Printf("Error failing stale deployment %s: %v", age.ID, err)
+			continue
+		}
+		if !moved {
+			continue
+		}
+		log.Printf("Deployment %s failed: %s", age.ID, reason)
+
+		err = r.queue.Publish("DEPLOYMENTS.status", domain.DeploymentStatusEvent{
+			DeploymentID: age.ID,
+			Status:       domain.StatusError,
+			Timestamp:    time.Now(),
+		})
+		if err != nil {
+			log.Printf("Error publishing status event: %v", err)
+		}
+	}
+}
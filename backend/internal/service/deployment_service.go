@@ -3,6 +3,9 @@ package service
 import (
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/dejavu/backend/internal/domain"
 	"github.com/dejavu/backend/internal/repository"
@@ -10,21 +13,38 @@ import (
 	"github.com/google/uuid"
 )
 
+// Cloning a large repository for analysis can take a while
+const analyzeTimeout = 60 * time.Second
+
+var (
+	ErrBuilderUnavailable = errors.New("no builder answered, try again later")
+	ErrInvalidTransition  = errors.New("deployment cannot move to that status from its current one")
+)
+
 type DeploymentService struct {
-	deployRepo  *repository.DeploymentRepository
-	projectRepo *repository.ProjectRepository
-	queue       *queue.Queue
+	deployRepo     *repository.DeploymentRepository
+	projectRepo    *repository.ProjectRepository
+	credentialRepo *repository.GitCredentialRepository
+	billingRepo    *repository.BillingRepository
+	envService     *EnvVarService
+	queue          *queue.Queue
 }
 
 func NewDeploymentService(
 	deployRepo *repository.DeploymentRepository,
 	projectRepo *repository.ProjectRepository,
+	credentialRepo *repository.GitCredentialRepository,
+	billingRepo *repository.BillingRepository,
+	envService *EnvVarService,
 	queue *queue.Queue,
 ) *DeploymentService {
 	return &DeploymentService{
-		deployRepo:  deployRepo,
-		projectRepo: projectRepo,
-		queue:       queue,
+		deployRepo:     deployRepo,
+		projectRepo:    projectRepo,
+		credentialRepo: credentialRepo,
+		billingRepo:    billingRepo,
+		envService:     envService,
+		queue:          queue,
 	}
 }
 
@@ -40,39 +60,332 @@ func (s *DeploymentService) Trigger(userID string, req *domain.TriggerDeployRequ
 	if project.UserID != userID {
 		return nil, errors.New("unauthorized")
 	}
+	// The account may have been downgraded since the plan was chosen
+	if err := checkTier(s.billingRepo, userID, buildPlan(project.Plan)); err != nil {
+		return nil, err
+	}
+
+	ref, refType, err := resolveRef(req, project.ProductionBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build-time env vars travel encrypted, like the credential; the builder
+	// decrypts them
+	buildEnv, err := s.envService.Sealed(project.ID, domain.EnvScopeBuild)
+	if err != nil {
+		return nil, err
+	}
+
+	gitCredential, err := s.gitCredential(project.ID)
+	if err != nil {
+		return nil, err
+	}
 
 	// Generate subdomain
 	subdomain := s.generateSubdomain()
 
 	// Create deployment record
 	deployment := &domain.Deployment{
-		ProjectID:  req.ProjectID,
-		Status:     domain.StatusPending,
-		Subdomain:  subdomain,
-		CommitHash: req.CommitHash,
+		ProjectID: req.ProjectID,
+		Kind:      domain.KindBuild,
+		Status:    domain.StatusPending,
+		Subdomain: subdomain,
+		Ref:       ref,
+		RefType:   refType,
 	}
 
 	if err := s.deployRepo.Create(deployment); err != nil {
 		return nil, err
 	}
+	if err := s.publishStatus(deployment); err != nil {
+		return nil, err
+	}
+
+	// Queued before the request goes out, so a builder never sees it pending
+	if err := s.transition(deployment, domain.StatusQueued, ""); err != nil {
+		return nil, err
+	}
 
 	// Publish to NATS for builder
 	event := domain.DeploymentEvent{
-		DeploymentID: deployment.ID,
-		ProjectID:    project.ID,
-		RepoURL:      project.RepoURL,
-		BuildCommand: project.BuildCommand,
-		OutputDir:    project.OutputDir,
-		CommitHash:   req.CommitHash,
+		DeploymentID:   deployment.ID,
+		ProjectID:      project.ID,
+		RepoURL:        project.RepoURL,
+		BuildCommand:   project.BuildCommand,
+		OutputDir:      project.OutputDir,
+		RootDirectory:  project.RootDirectory,
+		InstallCommand: project.InstallCommand,
+		Framework:      project.Framework,
+		Ref:            ref,
+		RefType:        refType,
+		BuildEnv:       buildEnv,
+		Credential:     gitCredential,
+		NoCache:        req.NoCache,
+		BuildTimeout:   int(buildPlan(project.Plan).BuildTimeout().Seconds()),
+		Docker:         project.Docker,
 	}
 
 	if err := s.queue.Publish("DEPLOYMENTS.request", event); err != nil {
+		s.transition(deployment, domain.StatusError, "could not queue the build")
+		return nil, err
+	}
+
+	return deployment, nil
+}
+
+// Cancel stops a deployment that is still queued or building. The builder
+// running it kills the build; one that picks it up later skips it.
+func (s *DeploymentService) Cancel(userID, id string) (*domain.Deployment, error) {
+	deployment, err := s.deployRepo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if deployment == nil {
+		return nil, errors.New("deployment not found")
+	}
+
+	project, err := s.projectRepo.GetByID(deployment.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil || project.UserID != userID {
+		return nil, errors.New("unauthorized")
+	}
+
+	if err := s.transition(deployment, domain.StatusCancelled, "cancelled by user"); err != nil {
+		if err == ErrInvalidTransition {
+			return nil, errors.New("only pending, queued or building deployments can be cancelled")
+		}
+		return nil, err
+	}
+
+	if err := s.queue.Publish("DEPLOYMENTS.cancel", domain.CancelBuildEvent{DeploymentID: id}); err != nil {
+		return nil, err
+	}
+
+	return deployment, nil
+}
+
+// buildPlan returns the plan whose build limits apply to a project
+func buildPlan(name string) *domain.Plan {
+	if plan := domain.GetPlan(name); plan != nil {
+		return plan
+	}
+	return domain.GetPlan(domain.DefaultPlan)
+}
+
+// Analyze asks a builder to detect the framework and build defaults of the
+// project's repository, without deploying it
+func (s *DeploymentService) Analyze(userID, projectID string, req *domain.AnalyzeRepositoryRequest) (*domain.RepositoryAnalysis, error) {
+	project, err := s.projectRepo.GetByID(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil {
+		return nil, errors.New("project not found")
+	}
+	if project.UserID != userID {
+		return nil, errors.New("unauthorized")
+	}
+
+	ref, refType, err := resolveRef(&domain.TriggerDeployRequest{Ref: req.Ref, RefType: req.RefType}, project.ProductionBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	rootDirectory := project.RootDirectory
+	if req.RootDirectory != nil {
+		if rootDirectory, err = cleanRootDirectory(*req.RootDirectory); err != nil {
+			return nil, err
+		}
+	}
+
+	gitCredential, err := s.gitCredential(project.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply domain.AnalyzeReply
+	err = s.queue.Request("BUILDER.analyze", domain.AnalyzeEvent{
+		RepoURL:       project.RepoURL,
+		Ref:           ref,
+		RefType:       refType,
+		RootDirectory: rootDirectory,
+		Credential:    gitCredential,
+	}, &reply, analyzeTimeout)
+	if err != nil {
+		return nil, ErrBuilderUnavailable
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+	return reply.Result, nil
+}
+
+// gitCredential returns the project's repository credential as sent to the
+// builder. The builder decrypts it itself, it never travels in clear.
+func (s *DeploymentService) gitCredential(projectID string) (*domain.GitCredentialEvent, error) {
+	credential, err := s.credentialRepo.GetByProjectID(projectID)
+	if err != nil || credential == nil {
+		return nil, err
+	}
+	return &domain.GitCredentialEvent{
+		Type:       credential.Type,
+		Username:   credential.Username,
+		Secret:     credential.Secret,
+		KnownHosts: credential.KnownHosts,
+	}, nil
+}
+
+// Rollback re-deploys the image of an earlier deployment than the one
+// currently serving production
+func (s *DeploymentService) Rollback(userID, sourceID string) (*domain.Deployment, error) {
+	return s.redeploy(userID, sourceID, domain.KindRollback)
+}
+
+// Promote puts the image of any ready deployment into production
+func (s *DeploymentService) Promote(userID, sourceID string) (*domain.Deployment, error) {
+	return s.redeploy(userID, sourceID, domain.KindPromote)
+}
+
+// redeploy records a new deployment that reuses the image of source and asks
+// the deployer to roll it out without rebuilding
+func (s *DeploymentService) redeploy(userID, sourceID string, kind domain.DeploymentKind) (*domain.Deployment, error) {
+	source, err := s.deployRepo.GetByID(sourceID)
+	if err != nil {
+		return nil, err
+	}
+	if source == nil {
+		return nil, errors.New("deployment not found")
+	}
+
+	project, err := s.projectRepo.GetByID(source.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil || project.UserID != userID {
+		return nil, errors.New("unauthorized")
+	}
+	if err := checkTier(s.billingRepo, userID, buildPlan(project.Plan)); err != nil {
+		return nil, err
+	}
+
+	redeployable := source.Status == domain.StatusReady || source.Status == domain.StatusArchived
+	if !redeployable || source.ImageURL == "" {
+		return nil, errors.New("only ready deployments can be redeployed")
+	}
+	if project.ProductionDeploymentID == source.ID {
+		return nil, errors.New("deployment is already in production")
+	}
+
+	if kind == domain.KindRollback && project.ProductionDeploymentID != "" {
+		current, err := s.deployRepo.GetByID(project.ProductionDeploymentID)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil && !source.CreatedAt.Before(current.CreatedAt) {
+			return nil, errors.New("can only roll back to an older deployment")
+		}
+	}
+
+	deployment := &domain.Deployment{
+		ProjectID:          project.ID,
+		Kind:               kind,
+		SourceDeploymentID: source.ID,
+		Status:             domain.StatusPending,
+		Subdomain:          s.generateSubdomain(),
+		ImageURL:           source.ImageURL,
+		Ref:                source.Ref,
+		RefType:            source.RefType,
+		CommitHash:         source.CommitHash,
+		CommitAuthor:       source.CommitAuthor,
+		CommitMessage:      source.CommitMessage,
+		Port:               source.Port,
+	}
+
+	if err := s.deployRepo.Create(deployment); err != nil {
+		return nil, err
+	}
+	if err := s.publishStatus(deployment); err != nil {
+		return nil, err
+	}
+
+	event := domain.PromoteEvent{
+		DeploymentID:       deployment.ID,
+		ProjectID:          project.ID,
+		SourceDeploymentID: source.ID,
+		ImageURL:           source.ImageURL,
+	}
+
+	if err := s.queue.Publish("DEPLOYMENTS.promote", event); err != nil {
+		s.transition(deployment, domain.StatusError, "could not queue the rollout")
 		return nil, err
 	}
 
 	return deployment, nil
 }
 
+var (
+	commitSHAPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	refNamePattern   = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+)
+
+// resolveRef works out what to build: the requested ref, the deprecated
+// commit_hash, or the production branch
+func resolveRef(req *domain.TriggerDeployRequest, productionBranch string) (string, domain.RefType, error) {
+	ref, refType := req.Ref, req.RefType
+	if ref == "" && req.CommitHash != "" {
+		ref, refType = req.CommitHash, domain.RefBranch
+		if commitSHAPattern.MatchString(strings.ToLower(ref)) {
+			refType = domain.RefCommit
+		}
+	}
+	if ref == "" {
+		ref, refType = productionBranch, domain.RefBranch
+	}
+	if refType == "" {
+		refType = domain.RefBranch
+	}
+
+	switch refType {
+	case domain.RefBranch, domain.RefTag:
+		if !refNamePattern.MatchString(ref) || strings.HasPrefix(ref, "-") || strings.Contains(ref, "..") {
+			return "", "", errors.New("invalid ref")
+		}
+	case domain.RefCommit:
+		ref = strings.ToLower(ref)
+		if !commitSHAPattern.MatchString(ref) {
+			return "", "", errors.New("commit ref must be a full 40-character SHA")
+		}
+	default:
+		return "", "", errors.New("ref_type must be branch, tag or commit")
+	}
+	return ref, refType, nil
+}
+
+// transition moves the deployment to status through the state machine and
+// announces the change
+func (s *DeploymentService) transition(deployment *domain.Deployment, status domain.DeploymentStatus, reason string) error {
+	moved, err := s.deployRepo.Transition(deployment.ID, status, reason)
+	if err != nil {
+		return err
+	}
+	if !moved {
+		return ErrInvalidTransition
+	}
+	deployment.Status = status
+	return s.publishStatus(deployment)
+}
+
+func (s *DeploymentService) publishStatus(deployment *domain.Deployment) error {
+	return s.queue.Publish("DEPLOYMENTS.status", domain.DeploymentStatusEvent{
+		DeploymentID: deployment.ID,
+		Status:       deployment.Status,
+		Timestamp:    time.Now(),
+	})
+}
+
 func (s *DeploymentService) GetStatus(id string) (*domain.Deployment, error) {
 	deployment, err := s.deployRepo.GetByID(id)
 	if err != nil {
@@ -84,6 +397,29 @@ func (s *DeploymentService) GetStatus(id string) (*domain.Deployment, error) {
 	return deployment, nil
 }
 
+// Timeline returns the status changes of a deployment and how long it spent
+// in each phase
+func (s *DeploymentService) Timeline(userID, id string) (*domain.DeploymentTimeline, error) {
+	deployment, err := s.GetStatus(id)
+	if err != nil {
+		return nil, err
+	}
+
+	project, err := s.projectRepo.GetByID(deployment.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil || project.UserID != userID {
+		return nil, errors.New("unauthorized")
+	}
+
+	events, err := s.deployRepo.ListTransitions(id)
+	if err != nil {
+		return nil, err
+	}
+	return domain.NewDeploymentTimeline(deployment, events, time.Now()), nil
+}
+
 func (s *DeploymentService) ListByProject(projectID string) ([]*domain.Deployment, error) {
 	return s.deployRepo.ListByProjectID(projectID)
 }
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/internal/repository"
+	"github.com/dejavu/backend/pkg/queue"
+)
+
+const domainChallengePrefix = "_dejavu-challenge."
+
+var hostnamePattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// DomainService manages custom domains. A domain is only routed once its
+// owner proves control over it with a TXT or CNAME record.
+type DomainService struct {
+	repo        *repository.DomainRepository
+	projectRepo *repository.ProjectRepository
+	queue       *queue.Queue
+	resolver    *net.Resolver
+	baseDomain  string
+}
+
+// NewDomainService uses the system resolver unless dnsServer ("host:port")
+// is set
+func NewDomainService(
+	repo *repository.DomainRepository,
+	projectRepo *repository.ProjectRepository,
+	queue *queue.Queue,
+	baseDomain string,
+	dnsServer string,
+) *DomainService {
+	resolver := net.DefaultResolver
+	if dnsServer != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{Timeout: 5 * time.Second}
+				return d.DialContext(ctx, network, dnsServer)
+			},
+		}
+	}
+
+	return &DomainService{
+		repo:        repo,
+		projectRepo: projectRepo,
+		queue:       queue,
+		resolver:    resolver,
+		baseDomain:  baseDomain,
+	}
+}
+
+func (s *DomainService) List(projectID, userID string) ([]*domain.CustomDomain, error) {
+	project, err := s.getProject(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	domains, err := s.repo.ListByProjectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range domains {
+		s.attachVerification(d, project)
+	}
+	return domains, nil
+}
+
+func (s *DomainService) Create(projectID, userID string, req *domain.CreateDomainRequest) (*domain.CustomDomain, error) {
+	project, err := s.getProject(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(req.Hostname)), ".")
+	if !hostnamePattern.MatchString(hostname) || len(hostname) > 253 {
+		return nil, errors.New("invalid hostname")
+	}
+	if hostname == s.baseDomain || strings.HasSuffix(hostname, "."+s.baseDomain) {
+		return nil, errors.New("hostname is reserved")
+	}
+
+	// Pending claims of other projects do not block the hostname, whoever
+	// verifies first gets it
+	verified, err := s.repo.HostnameVerified(hostname)
+	if err != nil {
+		return nil, err
+	}
+	if verified {
+		return nil, errors.New("hostname already in use")
+	}
+	claimed, err := s.repo.HostnameClaimed(projectID, hostname)
+	if err != nil {
+		return nil, err
+	}
+	if claimed {
+		return nil, errors.New("domain already added")
+	}
+
+	token, err := generateWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &domain.CustomDomain{
+		ProjectID:         projectID,
+		Hostname:          hostname,
+		VerificationToken: token,
+		Status:            domain.DomainPending,
+		TLSStatus:         domain.TLSNone,
+	}
+	if err := s.repo.Create(d); err != nil {
+		return nil, err
+	}
+
+	s.attachVerification(d, project)
+	return d, nil
+}
+
+// Verify checks the domain's DNS records and, on success, asks the deployer
+// to route the hostname and request a certificate for it
+func (s *DomainService) Verify(projectID, domainID, userID string) (*domain.CustomDomain, error) {
+	project, err := s.getProject(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := s.repo.GetByID(domainID)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil || d.ProjectID != projectID {
+		return nil, errors.New("domain not found")
+	}
+
+	if d.Status != domain.DomainVerified {
+		if err := s.checkDNS(d, project); err != nil {
+			if err := s.repo.SetLastError(d.ID, err.Error()); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+
+		claimed, err := s.repo.MarkVerified(d.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !claimed {
+			err := errors.New("hostname already verified by another project")
+			if err := s.repo.SetLastError(d.ID, err.Error()); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		if err := s.publishChange(projectID); err != nil {
+			return nil, err
+		}
+
+		if d, err = s.repo.GetByID(domainID); err != nil {
+			return nil, err
+		}
+	}
+
+	s.attachVerification(d, project)
+	return d, nil
+}
+
+func (s *DomainService) Delete(projectID, domainID, userID string) error {
+	if _, err := s.getProject(projectID, userID); err != nil {
+		return err
+	}
+
+	if err := s.repo.Delete(domainID, projectID); err != nil {
+		return err
+	}
+	return s.publishChange(projectID)
+}
+
+func (s *DomainService) checkDNS(d *domain.CustomDomain, project *domain.Project) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	expected := "dejavu-verify=" + d.VerificationToken
+	records, _ := s.resolver.LookupTXT(ctx, domainChallengePrefix+d.Hostname)
+	for _, record := range records {
+		if strings.TrimSpace(record) == expected {
+			return nil
+		}
+	}
+
+	target := s.cnameTarget(project)
+	cname, _ := s.resolver.LookupCNAME(ctx, d.Hostname)
+	if strings.TrimSuffix(strings.ToLower(cname), ".") == target {
+		return nil
+	}
+
+	return fmt.Errorf("verification failed: add TXT %s%s or CNAME to %s", domainChallengePrefix, d.Hostname, target)
+}
+
+func (s *DomainService) publishChange(projectID string) error {
+	return s.queue.Publish("DEPLOYMENTS.domains", domain.DomainsChangedEvent{ProjectID: projectID})
+}
+
+func (s *DomainService) attachVerification(d *domain.CustomDomain, project *domain.Project) {
+	d.Verification = &domain.DomainVerification{
+		TXTName:     domainChallengePrefix + d.Hostname,
+		TXTValue:    "dejavu-verify=" + d.VerificationToken,
+		CNAMETarget: s.cnameTarget(project),
+	}
+}
+
+func (s *DomainService) cnameTarget(project *domain.Project) string {
+	return project.Slug + "." + s.baseDomain
+}
+
+func (s *DomainService) getProject(projectID, userID string) (*domain.Project, error) {
+	project, err := s.projectRepo.GetByID(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil {
+		return nil, errors.New("project not found")
+	}
+	if project.UserID != userID {
+		return nil, errors.New("unauthorized")
+	}
+	return project, nil
+}
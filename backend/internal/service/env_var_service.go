@@ -0,0 +1,163 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/internal/repository"
+	"github.com/dejavu/backend/pkg/secret"
+)
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type EnvVarService struct {
+	repo        *repository.EnvVarRepository
+	projectRepo *repository.ProjectRepository
+	cipher      *secret.Cipher
+}
+
+func NewEnvVarService(
+	repo *repository.EnvVarRepository,
+	projectRepo *repository.ProjectRepository,
+	cipher *secret.Cipher,
+) *EnvVarService {
+	return &EnvVarService{
+		repo:        repo,
+		projectRepo: projectRepo,
+		cipher:      cipher,
+	}
+}
+
+func (s *EnvVarService) List(projectID, userID string) ([]*domain.EnvVar, error) {
+	if err := s.verifyOwnership(projectID, userID); err != nil {
+		return nil, err
+	}
+
+	envVars, err := s.repo.ListByProjectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, envVar := range envVars {
+		value, err := s.cipher.Decrypt(envVar.Value)
+		if err != nil {
+			return nil, err
+		}
+		envVar.Value = value
+	}
+
+	return envVars, nil
+}
+
+func (s *EnvVarService) Create(projectID, userID string, req *domain.CreateEnvVarRequest) (*domain.EnvVar, error) {
+	if err := s.verifyOwnership(projectID, userID); err != nil {
+		return nil, err
+	}
+
+	if !envKeyPattern.MatchString(req.Key) {
+		return nil, errors.New("invalid env var key")
+	}
+
+	scope := req.Scope
+	if scope == "" {
+		scope = domain.EnvScopeRuntime
+	}
+	if !validEnvScope(scope) {
+		return nil, errors.New("invalid env var scope")
+	}
+
+	encrypted, err := s.cipher.Encrypt(req.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	envVar := &domain.EnvVar{
+		ProjectID: projectID,
+		Key:       req.Key,
+		Value:     encrypted,
+		Scope:     scope,
+	}
+
+	if err := s.repo.Create(envVar); err != nil {
+		return nil, err
+	}
+
+	envVar.Value = req.Value
+	return envVar, nil
+}
+
+func (s *EnvVarService) Update(projectID, envVarID, userID string, req *domain.UpdateEnvVarRequest) error {
+	if err := s.verifyOwnership(projectID, userID); err != nil {
+		return err
+	}
+
+	envVar, err := s.repo.GetByID(envVarID)
+	if err != nil {
+		return err
+	}
+	if envVar == nil || envVar.ProjectID != projectID {
+		return errors.New("env var not found")
+	}
+
+	if req.Scope != "" {
+		if !validEnvScope(req.Scope) {
+			return errors.New("invalid env var scope")
+		}
+		envVar.Scope = req.Scope
+	}
+
+	if req.Value != nil {
+		encrypted, err := s.cipher.Encrypt(*req.Value)
+		if err != nil {
+			return err
+		}
+		envVar.Value = encrypted
+	}
+
+	return s.repo.Update(envVar)
+}
+
+func (s *EnvVarService) Delete(projectID, envVarID, userID string) error {
+	if err := s.verifyOwnership(projectID, userID); err != nil {
+		return err
+	}
+	return s.repo.Delete(envVarID, projectID)
+}
+
+// Sealed returns the variables of a project for the given scope with their
+// values encrypted as stored, for services that decrypt them themselves
+func (s *EnvVarService) Sealed(projectID string, scope domain.EnvScope) (map[string]string, error) {
+	envVars, err := s.repo.ListByProjectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	env := map[string]string{}
+	for _, envVar := range envVars {
+		if envVar.Scope != scope {
+			continue
+		}
+		env[envVar.Key] = envVar.Value
+	}
+
+	return env, nil
+}
+
+func (s *EnvVarService) verifyOwnership(projectID, userID string) error {
+	project, err := s.projectRepo.GetByID(projectID)
+	if err != nil {
+		return err
+	}
+	if project == nil {
+		return errors.New("project not found")
+	}
+	if project.UserID != userID {
+		return errors.New("unauthorized")
+	}
+	return nil
+}
+
+func validEnvScope(scope domain.EnvScope) bool {
+	return scope == domain.EnvScopeBuild || scope == domain.EnvScopeRuntime
+}
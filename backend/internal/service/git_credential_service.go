@@ -0,0 +1,182 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"strings"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/internal/repository"
+	"github.com/dejavu/backend/pkg/secret"
+	"golang.org/x/crypto/ssh"
+)
+
+// Username GitHub expects for token authentication; GitLab and Gitea accept
+// any non-empty name
+const defaultGitUsername = "x-access-token"
+
+type GitCredentialService struct {
+	repo        *repository.GitCredentialRepository
+	projectRepo *repository.ProjectRepository
+	cipher      *secret.Cipher
+}
+
+func NewGitCredentialService(
+	repo *repository.GitCredentialRepository,
+	projectRepo *repository.ProjectRepository,
+	cipher *secret.Cipher,
+) *GitCredentialService {
+	return &GitCredentialService{
+		repo:        repo,
+		projectRepo: projectRepo,
+		cipher:      cipher,
+	}
+}
+
+func (s *GitCredentialService) Get(projectID, userID string) (*domain.GitCredential, error) {
+	if err := s.verifyOwnership(projectID, userID); err != nil {
+		return nil, err
+	}
+
+	credential, err := s.repo.GetByProjectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if credential == nil {
+		return nil, errors.New("no credential configured")
+	}
+	return credential, nil
+}
+
+// GenerateDeployKey creates a new ed25519 key pair for the project. Only the
+// public half is ever returned; it replaces any previous credential.
+func (s *GitCredentialService) GenerateDeployKey(projectID, userID string) (*domain.GitCredential, error) {
+	project, err := s.getOwnedProject(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	comment := "dejavu-" + project.Slug
+	block, err := ssh.MarshalPrivateKey(privateKey, comment)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := s.cipher.Encrypt(string(pem.EncodeToMemory(block)))
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &domain.GitCredential{
+		ProjectID: projectID,
+		Type:      domain.GitCredentialDeployKey,
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublicKey))) + " " + comment,
+		Secret:    encrypted,
+	}
+	if err := s.repo.Save(credential); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// SetToken stores an HTTPS access token, replacing any previous credential
+func (s *GitCredentialService) SetToken(projectID, userID string, req *domain.SetGitTokenRequest) (*domain.GitCredential, error) {
+	if err := s.verifyOwnership(projectID, userID); err != nil {
+		return nil, err
+	}
+
+	if req.Token == "" {
+		return nil, errors.New("token is required")
+	}
+	if strings.ContainsAny(req.Token+req.Username, "\r\n") {
+		return nil, errors.New("token and username must be a single line")
+	}
+
+	username := req.Username
+	if username == "" {
+		username = defaultGitUsername
+	}
+
+	encrypted, err := s.cipher.Encrypt(req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &domain.GitCredential{
+		ProjectID: projectID,
+		Type:      domain.GitCredentialToken,
+		Username:  username,
+		Secret:    encrypted,
+	}
+	if err := s.repo.Save(credential); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// SetKnownHosts sets the host keys the builder trusts, besides the built-in
+// ones, when cloning with the project's deploy key, e.g. of a self-hosted Git
+// server
+func (s *GitCredentialService) SetKnownHosts(projectID, userID string, req *domain.SetKnownHostsRequest) (*domain.GitCredential, error) {
+	credential, err := s.Get(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if credential.Type != domain.GitCredentialDeployKey {
+		return nil, errors.New("known hosts are only used with deploy keys")
+	}
+
+	for _, line := range strings.Split(req.KnownHosts, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, _, _, _, _, err := ssh.ParseKnownHosts([]byte(line)); err != nil {
+			return nil, errors.New("invalid known_hosts line: " + line)
+		}
+	}
+
+	if err := s.repo.SetKnownHosts(projectID, req.KnownHosts); err != nil {
+		return nil, err
+	}
+	credential.KnownHosts = req.KnownHosts
+	return credential, nil
+}
+
+func (s *GitCredentialService) Delete(projectID, userID string) error {
+	if err := s.verifyOwnership(projectID, userID); err != nil {
+		return err
+	}
+	return s.repo.Delete(projectID)
+}
+
+func (s *GitCredentialService) verifyOwnership(projectID, userID string) error {
+	_, err := s.getOwnedProject(projectID, userID)
+	return err
+}
+
+func (s *GitCredentialService) getOwnedProject(projectID, userID string) (*domain.Project, error) {
+	project, err := s.projectRepo.GetByID(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil {
+		return nil, errors.New("project not found")
+	}
+	if project.UserID != userID {
+		return nil, errors.New("unauthorized")
+	}
+	return project, nil
+}
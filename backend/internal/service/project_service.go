@@ -2,36 +2,102 @@ package service
 
 import (
 	"errors"
+	"path"
+	"regexp"
+	"strings"
 
 	"github.com/dejavu/backend/internal/domain"
 	"github.com/dejavu/backend/internal/repository"
+	"github.com/dejavu/backend/pkg/queue"
+	"github.com/google/uuid"
 )
 
+var slugInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+
 type ProjectService struct {
-	repo *repository.ProjectRepository
+	repo        *repository.ProjectRepository
+	deployRepo  *repository.DeploymentRepository
+	billingRepo *repository.BillingRepository
+	queue       *queue.Queue
 }
 
-func NewProjectService(repo *repository.ProjectRepository) *ProjectService {
-	return &ProjectService{repo: repo}
+func NewProjectService(
+	repo *repository.ProjectRepository,
+	deployRepo *repository.DeploymentRepository,
+	billingRepo *repository.BillingRepository,
+	queue *queue.Queue,
+) *ProjectService {
+	return &ProjectService{
+		repo:        repo,
+		deployRepo:  deployRepo,
+		billingRepo: billingRepo,
+		queue:       queue,
+	}
 }
 
 func (s *ProjectService) Create(userID string, req *domain.CreateProjectRequest) (*domain.Project, error) {
-	buildCmd := req.BuildCommand
-	if buildCmd == "" {
-		buildCmd = "npm run build"
+	productionBranch := req.ProductionBranch
+	if productionBranch == "" {
+		productionBranch = "main"
+	}
+
+	if err := validatePort(req.Port); err != nil {
+		return nil, err
+	}
+
+	rootDirectory, err := cleanRootDirectory(req.RootDirectory)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateFramework(req.Framework); err != nil {
+		return nil, err
 	}
 
-	outputDir := req.OutputDir
-	if outputDir == "" {
-		outputDir = "dist"
+	healthChecks := domain.HealthChecks{}
+	if req.HealthChecks != nil {
+		if err := req.HealthChecks.Validate(); err != nil {
+			return nil, err
+		}
+		healthChecks = *req.HealthChecks
+	}
+
+	docker := domain.DockerSettings{}
+	if req.Docker != nil {
+		if docker, err = cleanDockerSettings(*req.Docker); err != nil {
+			return nil, err
+		}
+	}
+
+	planName := req.Plan
+	if planName == "" {
+		planName = domain.DefaultPlan
+	}
+	scaling, err := s.resolveScaling(userID, planName, nil, req.Scaling)
+	if err != nil {
+		return nil, err
+	}
+
+	slug, err := s.generateSlug(req.Name)
+	if err != nil {
+		return nil, err
 	}
 
 	project := &domain.Project{
-		UserID:       userID,
-		Name:         req.Name,
-		RepoURL:      req.RepoURL,
-		BuildCommand: buildCmd,
-		OutputDir:    outputDir,
+		UserID:           userID,
+		Name:             req.Name,
+		Slug:             slug,
+		RepoURL:          req.RepoURL,
+		BuildCommand:     req.BuildCommand,
+		OutputDir:        req.OutputDir,
+		ProductionBranch: productionBranch,
+		RootDirectory:    rootDirectory,
+		InstallCommand:   req.InstallCommand,
+		Framework:        req.Framework,
+		Port:             req.Port,
+		HealthChecks:     healthChecks,
+		Docker:           docker,
+		Plan:             planName,
+		Scaling:          *scaling,
 	}
 
 	if err := s.repo.Create(project); err != nil {
@@ -41,6 +107,29 @@ func (s *ProjectService) Create(userID string, req *domain.CreateProjectRequest)
 	return project, nil
 }
 
+// generateSlug derives the DNS label used for the production domain
+// <slug>.<BASE_DOMAIN>. It never changes after the project is created.
+func (s *ProjectService) generateSlug(name string) (string, error) {
+	slug := strings.Trim(slugInvalidChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(slug) > 40 {
+		slug = strings.Trim(slug[:40], "-")
+	}
+	// Keep clear of generated preview subdomains (app-xxxxxxxx)
+	if slug == "" || strings.HasPrefix(slug, "app-") {
+		slug = strings.Trim("project-"+slug, "-")
+	}
+
+	exists, err := s.repo.SlugExists(slug)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		slug = slug + "-" + uuid.New().String()[:6]
+	}
+
+	return slug, nil
+}
+
 func (s *ProjectService) GetByID(id, userID string) (*domain.Project, error) {
 	project, err := s.repo.GetByID(id)
 	if err != nil {
@@ -71,16 +160,190 @@ func (s *ProjectService) Update(id, userID string, req *domain.UpdateProjectRequ
 	if req.RepoURL != "" {
 		project.RepoURL = req.RepoURL
 	}
-	if req.BuildCommand != "" {
-		project.BuildCommand = req.BuildCommand
+	if req.BuildCommand != nil {
+		project.BuildCommand = *req.BuildCommand
+	}
+	if req.OutputDir != nil {
+		project.OutputDir = *req.OutputDir
+	}
+	if req.ProductionBranch != "" {
+		project.ProductionBranch = req.ProductionBranch
+	}
+	if req.RootDirectory != nil {
+		rootDirectory, err := cleanRootDirectory(*req.RootDirectory)
+		if err != nil {
+			return err
+		}
+		project.RootDirectory = rootDirectory
+	}
+	if req.InstallCommand != nil {
+		project.InstallCommand = *req.InstallCommand
 	}
-	if req.OutputDir != "" {
-		project.OutputDir = req.OutputDir
+	if req.Framework != nil {
+		if err := validateFramework(*req.Framework); err != nil {
+			return err
+		}
+		project.Framework = *req.Framework
+	}
+	if req.Port != nil {
+		if err := validatePort(*req.Port); err != nil {
+			return err
+		}
+		project.Port = *req.Port
+	}
+	if req.HealthChecks != nil {
+		if err := req.HealthChecks.Validate(); err != nil {
+			return err
+		}
+		project.HealthChecks = *req.HealthChecks
+	}
+	if req.Docker != nil {
+		docker, err := cleanDockerSettings(*req.Docker)
+		if err != nil {
+			return err
+		}
+		project.Docker = docker
+	}
+	if (req.Plan != "" && req.Plan != project.Plan) || req.Scaling != nil {
+		// Switching plans starts over from the new plan's defaults
+		current := &project.Scaling
+		if req.Plan != "" && req.Plan != project.Plan {
+			project.Plan = req.Plan
+			current = nil
+		}
+		scaling, err := s.resolveScaling(userID, project.Plan, current, req.Scaling)
+		if err != nil {
+			return err
+		}
+		project.Scaling = *scaling
 	}
 
 	return s.repo.Update(project)
 }
 
+// Delete removes the project and asks the deployer to tear down everything
+// it created in the cluster for it
 func (s *ProjectService) Delete(id, userID string) error {
-	return s.repo.Delete(id, userID)
+	project, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if project == nil || project.UserID != userID {
+		return errors.New("project not found")
+	}
+
+	// Deployment rows cascade with the project, collect them first
+	deployments, err := s.deployRepo.ListByProjectID(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.Delete(id, userID); err != nil {
+		return err
+	}
+
+	event := domain.ProjectTeardownEvent{ProjectID: id}
+	for _, d := range deployments {
+		event.DeploymentIDs = append(event.DeploymentIDs, d.ID)
+	}
+	return s.queue.Publish("DEPLOYMENTS.teardown", event)
+}
+
+// validatePort accepts 0 (use the framework default) or a TCP port
+func validatePort(port int) error {
+	if port < 0 || port > 65535 {
+		return errors.New("port must be between 1 and 65535")
+	}
+	return nil
+}
+
+// cleanRootDirectory normalizes a monorepo subdirectory to a relative
+// slash-separated path, "" for the repository root
+func cleanRootDirectory(dir string) (string, error) {
+	return cleanRepoPath("root_directory", dir)
+}
+
+// cleanDockerSettings validates the Docker build settings and normalizes the
+// Dockerfile path
+func cleanDockerSettings(docker domain.DockerSettings) (domain.DockerSettings, error) {
+	if err := docker.Validate(); err != nil {
+		return docker, err
+	}
+	dockerfile, err := cleanRepoPath("dockerfile", docker.Dockerfile)
+	if err != nil {
+		return docker, err
+	}
+	docker.Dockerfile = dockerfile
+	return docker, nil
+}
+
+// cleanRepoPath normalizes a path inside the repository to a relative
+// slash-separated path, "" for the top
+func cleanRepoPath(field, dir string) (string, error) {
+	dir = strings.TrimSpace(dir)
+	if dir == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(dir, "/") || strings.Contains(dir, "\\") {
+		return "", errors.New(field + " must be a relative path")
+	}
+
+	cleaned := path.Clean(dir)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", errors.New(field + " must stay inside the repository")
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+func validateFramework(framework string) error {
+	if framework == "" {
+		return nil
+	}
+	for _, f := range domain.Frameworks {
+		if f == framework {
+			return nil
+		}
+	}
+	return errors.New("framework must be one of " + strings.Join(domain.Frameworks, ", "))
+}
+
+// resolveScaling applies overrides to current (or the plan defaults when
+// current is nil) and checks the result against the plan and the user's
+// billing tier
+func (s *ProjectService) resolveScaling(userID, planName string, current *domain.Scaling, overrides *domain.ScalingOverrides) (*domain.Scaling, error) {
+	plan := domain.GetPlan(planName)
+	if plan == nil {
+		return nil, errors.New("unknown plan: " + planName)
+	}
+
+	if err := checkTier(s.billingRepo, userID, plan); err != nil {
+		return nil, err
+	}
+
+	scaling := plan.Defaults
+	if current != nil {
+		scaling = *current
+	}
+	overrides.Apply(&scaling)
+
+	if err := plan.Validate(scaling); err != nil {
+		return nil, err
+	}
+	return &scaling, nil
+}
+
+// checkTier fails unless the user's billing tier includes plan
+func checkTier(billingRepo *repository.BillingRepository, userID string, plan *domain.Plan) error {
+	account, err := billingRepo.GetOrCreateAccount(userID)
+	if err != nil {
+		return err
+	}
+	tier := domain.GetPlan(account.Tier)
+	if tier == nil || plan.Rank > tier.Rank {
+		return errors.New("the " + plan.Name + " plan is not included in your billing tier")
+	}
+	return nil
 }
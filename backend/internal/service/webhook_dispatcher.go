@@ -0,0 +1,251 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/internal/repository"
+	"github.com/dejavu/backend/pkg/queue"
+)
+
+const (
+	webhookMaxAttempts  = 5
+	webhookInitialDelay = 2 * time.Second
+
+	// How often due deliveries are looked for, and how many are sent at once
+	webhookPollInterval = time.Second
+	webhookBatchSize    = 20
+	// A claimed delivery goes to another dispatcher after this long, e.g.
+	// when this one died while sending it
+	webhookLease = 30 * time.Second
+)
+
+// WebhookDispatcher turns deployment status changes into signed outbound
+// webhook deliveries. Deliveries are queued in the database before the status
+// event is acknowledged and retried from there, so none is lost on restart.
+type WebhookDispatcher struct {
+	repo       *repository.WebhookRepository
+	deployRepo *repository.DeploymentRepository
+	queue      *queue.Queue
+	client     *http.Client
+	wake       chan struct{}
+}
+
+func NewWebhookDispatcher(
+	repo *repository.WebhookRepository,
+	deployRepo *repository.DeploymentRepository,
+	queue *queue.Queue,
+) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		repo:       repo,
+		deployRepo: deployRepo,
+		queue:      queue,
+		client:     newWebhookClient(),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+func (d *WebhookDispatcher) Start() error {
+	_, err := d.queue.QueueSubscribe("DEPLOYMENTS.status", "webhook-dispatcher", func(data []byte) {
+		var event domain.DeploymentStatusEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			log.Printf("Error parsing status event: %v", err)
+			return
+		}
+		d.dispatch(event)
+	})
+	if err != nil {
+		return err
+	}
+
+	go d.run()
+	return nil
+}
+
+// dispatch queues a delivery of the event for every subscribed webhook
+func (d *WebhookDispatcher) dispatch(event domain.DeploymentStatusEvent) {
+	name := webhookEventForStatus(event.Status)
+	if name == "" {
+		return
+	}
+
+	deployment, err := d.deployRepo.GetByID(event.DeploymentID)
+	if err != nil || deployment == nil {
+		log.Printf("Error loading deployment %s for webhooks: %v", event.DeploymentID, err)
+		return
+	}
+
+	webhooks, err := d.repo.ListSubscribed(deployment.ProjectID, name)
+	if err != nil {
+		log.Printf("Error loading webhooks: %v", err)
+		return
+	}
+
+	payload, err := json.Marshal(domain.WebhookPayload{
+		Event:      name,
+		Timestamp:  event.Timestamp,
+		Deployment: deployment,
+	})
+	if err != nil {
+		log.Printf("Error encoding webhook payload: %v", err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		job := &domain.WebhookJob{
+			WebhookID:    webhook.ID,
+			DeploymentID: deployment.ID,
+			Event:        name,
+			Payload:      payload,
+		}
+		if err := d.repo.EnqueueJob(job); err != nil {
+			log.Printf("Error queueing webhook delivery: %v", err)
+		}
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends due deliveries, checking on every new delivery and once per
+// poll interval for retries
+func (d *WebhookDispatcher) run() {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		}
+
+		for {
+			jobs, err := d.repo.ClaimDueJobs(webhookBatchSize, webhookLease)
+			if err != nil {
+				log.Printf("Error claiming webhook deliveries: %v", err)
+				break
+			}
+
+			var wg sync.WaitGroup
+			for _, job := range jobs {
+				wg.Add(1)
+				go func(job *domain.WebhookJob) {
+					defer wg.Done()
+					d.deliver(job)
+				}(job)
+			}
+			wg.Wait()
+
+			if len(jobs) < webhookBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// deliver makes one attempt at a delivery, records it and schedules the next
+// one with exponential backoff on failure
+func (d *WebhookDispatcher) deliver(job *domain.WebhookJob) {
+	webhook, err := d.repo.GetByID(job.WebhookID)
+	if err != nil {
+		log.Printf("Error loading webhook %s: %v", job.WebhookID, err)
+		return
+	}
+	if webhook == nil || !webhook.Active {
+		d.finish(job)
+		return
+	}
+
+	statusCode, err := d.send(webhook, job.ID, job.Event, job.Payload)
+
+	delivery := &domain.WebhookDelivery{
+		WebhookID:    webhook.ID,
+		DeploymentID: job.DeploymentID,
+		Event:        job.Event,
+		Attempt:      job.Attempt,
+		StatusCode:   statusCode,
+		Success:      err == nil,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	if recordErr := d.repo.RecordDelivery(delivery); recordErr != nil {
+		log.Printf("Error recording webhook delivery: %v", recordErr)
+	}
+
+	switch {
+	case err == nil:
+		d.finish(job)
+	case job.Attempt >= webhookMaxAttempts:
+		log.Printf("Webhook %s gave up delivering %s for %s", webhook.ID, job.Event, job.DeploymentID)
+		d.finish(job)
+	default:
+		delay := webhookInitialDelay << (job.Attempt - 1)
+		if err := d.repo.RescheduleJob(job.ID, delay); err != nil {
+			log.Printf("Error scheduling webhook retry: %v", err)
+		}
+	}
+}
+
+func (d *WebhookDispatcher) finish(job *domain.WebhookJob) {
+	if err := d.repo.DeleteJob(job.ID); err != nil {
+		log.Printf("Error removing webhook delivery %s: %v", job.ID, err)
+	}
+}
+
+func (d *WebhookDispatcher) send(webhook *domain.Webhook, deliveryID, event string, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Dejavu-Webhook")
+	req.Header.Set("X-Dejavu-Event", event)
+	req.Header.Set("X-Dejavu-Delivery", deliveryID)
+	req.Header.Set("X-Dejavu-Signature", "sha256="+signPayload(webhook.Secret, payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookEventForStatus(status domain.DeploymentStatus) string {
+	switch status {
+	case domain.StatusPending:
+		return domain.EventDeploymentStarted
+	case domain.StatusBuilding:
+		return domain.EventDeploymentBuilding
+	case domain.StatusReady:
+		return domain.EventDeploymentReady
+	case domain.StatusError, domain.StatusTimedOut:
+		return domain.EventDeploymentFailed
+	case domain.StatusCancelled:
+		return domain.EventDeploymentCancelled
+	}
+	return ""
+}
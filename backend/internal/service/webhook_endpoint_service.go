@@ -0,0 +1,169 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/internal/repository"
+)
+
+// WebhookEndpointService manages the outbound webhooks a project notifies
+// about deployment lifecycle events
+type WebhookEndpointService struct {
+	repo        *repository.WebhookRepository
+	projectRepo *repository.ProjectRepository
+}
+
+func NewWebhookEndpointService(repo *repository.WebhookRepository, projectRepo *repository.ProjectRepository) *WebhookEndpointService {
+	return &WebhookEndpointService{
+		repo:        repo,
+		projectRepo: projectRepo,
+	}
+}
+
+func (s *WebhookEndpointService) List(projectID, userID string) ([]*domain.Webhook, error) {
+	if err := s.verifyOwnership(projectID, userID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListByProjectID(projectID)
+}
+
+func (s *WebhookEndpointService) Create(projectID, userID string, req *domain.CreateWebhookRequest) (*domain.Webhook, error) {
+	if err := s.verifyOwnership(projectID, userID); err != nil {
+		return nil, err
+	}
+
+	if err := validateWebhookURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	events := req.Events
+	if len(events) == 0 {
+		events = domain.WebhookEvents
+	}
+	if err := validateWebhookEvents(events); err != nil {
+		return nil, err
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	webhook := &domain.Webhook{
+		ProjectID: projectID,
+		URL:       req.URL,
+		Secret:    secret,
+		Events:    events,
+		Active:    true,
+	}
+
+	if err := s.repo.Create(webhook); err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (s *WebhookEndpointService) Update(projectID, webhookID, userID string, req *domain.UpdateWebhookRequest) error {
+	webhook, err := s.get(projectID, webhookID, userID)
+	if err != nil {
+		return err
+	}
+
+	if req.URL != "" {
+		if err := validateWebhookURL(req.URL); err != nil {
+			return err
+		}
+		webhook.URL = req.URL
+	}
+	if len(req.Events) > 0 {
+		if err := validateWebhookEvents(req.Events); err != nil {
+			return err
+		}
+		webhook.Events = req.Events
+	}
+	if req.Active != nil {
+		webhook.Active = *req.Active
+	}
+
+	return s.repo.Update(webhook)
+}
+
+func (s *WebhookEndpointService) Delete(projectID, webhookID, userID string) error {
+	if err := s.verifyOwnership(projectID, userID); err != nil {
+		return err
+	}
+	return s.repo.Delete(webhookID, projectID)
+}
+
+func (s *WebhookEndpointService) ListDeliveries(projectID, webhookID, userID string) ([]*domain.WebhookDelivery, error) {
+	if _, err := s.get(projectID, webhookID, userID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListDeliveries(webhookID, 50)
+}
+
+func (s *WebhookEndpointService) get(projectID, webhookID, userID string) (*domain.Webhook, error) {
+	if err := s.verifyOwnership(projectID, userID); err != nil {
+		return nil, err
+	}
+
+	webhook, err := s.repo.GetByID(webhookID)
+	if err != nil {
+		return nil, err
+	}
+	if webhook == nil || webhook.ProjectID != projectID {
+		return nil, errors.New("webhook not found")
+	}
+	return webhook, nil
+}
+
+func (s *WebhookEndpointService) verifyOwnership(projectID, userID string) error {
+	project, err := s.projectRepo.GetByID(projectID)
+	if err != nil {
+		return err
+	}
+	if project == nil {
+		return errors.New("project not found")
+	}
+	if project.UserID != userID {
+		return errors.New("unauthorized")
+	}
+	return nil
+}
+
+func validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("invalid webhook url")
+	}
+	return checkWebhookHost(u.Hostname())
+}
+
+func validateWebhookEvents(events []string) error {
+	for _, event := range events {
+		valid := false
+		for _, known := range domain.WebhookEvents {
+			if event == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return errors.New("unknown webhook event: " + event)
+		}
+	}
+	return nil
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
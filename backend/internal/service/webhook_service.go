@@ -0,0 +1,206 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/dejavu/backend/internal/domain"
+	"github.com/dejavu/backend/internal/repository"
+)
+
+var (
+	ErrUnsupportedProvider = errors.New("unsupported webhook provider")
+	ErrInvalidSignature    = errors.New("invalid webhook signature")
+)
+
+// PushEvent is the provider-independent part of a git push webhook
+type PushEvent struct {
+	Branch     string
+	CommitHash string
+	Deleted    bool
+
+	// Files added, modified or removed by the pushed commits. Nil when the
+	// provider did not list them, e.g. for very large pushes.
+	ChangedFiles []string
+}
+
+type WebhookService struct {
+	projectRepo   *repository.ProjectRepository
+	deployService *DeploymentService
+}
+
+func NewWebhookService(projectRepo *repository.ProjectRepository, deployService *DeploymentService) *WebhookService {
+	return &WebhookService{
+		projectRepo:   projectRepo,
+		deployService: deployService,
+	}
+}
+
+// HandlePush verifies and parses a push webhook and triggers a deployment when
+// it targets the project's production branch. It returns a nil deployment when
+// the push was valid but ignored.
+func (s *WebhookService) HandlePush(provider, projectID string, header func(string) string, body []byte) (*domain.Deployment, error) {
+	project, err := s.projectRepo.GetByID(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil {
+		return nil, errors.New("project not found")
+	}
+
+	if err := verifyWebhookSignature(provider, project.WebhookSecret, header, body); err != nil {
+		return nil, err
+	}
+
+	if !isPushEvent(provider, header) {
+		return nil, nil
+	}
+
+	push, err := parsePushEvent(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if push.Deleted || push.Branch != project.ProductionBranch {
+		return nil, nil
+	}
+
+	// Monorepo projects only deploy when their own directory changed
+	if !touchesDirectory(push.ChangedFiles, project.RootDirectory) {
+		return nil, nil
+	}
+
+	return s.deployService.Trigger(project.UserID, &domain.TriggerDeployRequest{
+		ProjectID: project.ID,
+		Ref:       push.CommitHash,
+		RefType:   domain.RefCommit,
+	})
+}
+
+func verifyWebhookSignature(provider, secret string, header func(string) string, body []byte) error {
+	if secret == "" {
+		return ErrInvalidSignature
+	}
+
+	switch provider {
+	case "github":
+		signature := strings.TrimPrefix(header("X-Hub-Signature-256"), "sha256=")
+		if !validHMAC(secret, signature, body) {
+			return ErrInvalidSignature
+		}
+	case "gitea":
+		if !validHMAC(secret, header("X-Gitea-Signature"), body) {
+			return ErrInvalidSignature
+		}
+	case "gitlab":
+		// GitLab sends the shared secret as-is instead of signing the body
+		token := header("X-Gitlab-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			return ErrInvalidSignature
+		}
+	default:
+		return ErrUnsupportedProvider
+	}
+
+	return nil
+}
+
+func validHMAC(secret, signature string, body []byte) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func isPushEvent(provider string, header func(string) string) bool {
+	switch provider {
+	case "github":
+		return header("X-GitHub-Event") == "push"
+	case "gitea":
+		return header("X-Gitea-Event") == "push"
+	case "gitlab":
+		return header("X-Gitlab-Event") == "Push Hook"
+	}
+	return false
+}
+
+// GitHub lists at most this many commits of a push and gives no total
+const githubCommitLimit = 20
+
+func parsePushEvent(body []byte) (*PushEvent, error) {
+	// GitHub, GitLab and Gitea share the same basic push payload shape
+	var payload struct {
+		Ref         string `json:"ref"`
+		After       string `json:"after"`
+		CheckoutSHA string `json:"checkout_sha"`
+		Deleted     bool   `json:"deleted"`
+		// GitLab and Gitea count the commits of a push they do not list
+		TotalCommitsCount int `json:"total_commits_count"`
+		TotalCommits      int `json:"total_commits"`
+		Commits           []struct {
+			Added    []string `json:"added"`
+			Modified []string `json:"modified"`
+			Removed  []string `json:"removed"`
+		} `json:"commits"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, errors.New("invalid push payload")
+	}
+
+	if !strings.HasPrefix(payload.Ref, "refs/heads/") {
+		// Tag pushes are not deployed
+		return &PushEvent{Deleted: payload.Deleted}, nil
+	}
+
+	commitHash := payload.After
+	if payload.CheckoutSHA != "" {
+		commitHash = payload.CheckoutSHA
+	}
+
+	// A deleted branch reports an all-zero SHA
+	deleted := payload.Deleted || strings.Trim(commitHash, "0") == ""
+
+	// A truncated commit list may leave out the files that matter, so the
+	// push counts as changing everything
+	listed := len(payload.Commits)
+	truncated := listed >= githubCommitLimit || payload.TotalCommitsCount > listed || payload.TotalCommits > listed
+
+	var changedFiles []string
+	if !truncated {
+		for _, commit := range payload.Commits {
+			changedFiles = append(changedFiles, commit.Added...)
+			changedFiles = append(changedFiles, commit.Modified...)
+			changedFiles = append(changedFiles, commit.Removed...)
+		}
+	}
+
+	return &PushEvent{
+		Branch:       strings.TrimPrefix(payload.Ref, "refs/heads/"),
+		CommitHash:   commitHash,
+		Deleted:      deleted,
+		ChangedFiles: changedFiles,
+	}, nil
+}
+
+// touchesDirectory reports whether any changed file lies under dir. Without
+// a list of changed files it errs on the side of deploying.
+func touchesDirectory(changedFiles []string, dir string) bool {
+	if dir == "" || changedFiles == nil {
+		return true
+	}
+	for _, file := range changedFiles {
+		if file == dir || strings.HasPrefix(file, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -3,10 +3,14 @@ package queue
 import (
 	"encoding/json"
 	"os"
+	"time"
 
 	"github.com/nats-io/nats.go"
 )
 
+// How long the BUILDS stream keeps messages, build logs included
+const buildsMaxAge = 24 * time.Hour
+
 type Queue struct {
 	conn *nats.Conn
 	js   nats.JetStreamContext
@@ -29,22 +33,44 @@ func Connect() (*Queue, error) {
 	}
 
 	// Create streams
-	streams := []string{
-		"DEPLOYMENTS",
-		"BUILDS",
+	streams := []struct {
+		name   string
+		maxAge time.Duration // 0 keeps messages until they are deleted
+	}{
+		{"DEPLOYMENTS", 0},
+		// Build logs are persisted by the log collector and pruned in Postgres;
+		// the stream only has to keep them until the collector and live tails
+		// have read them
+		{"BUILDS", buildsMaxAge},
 	}
 
 	for _, stream := range streams {
-		_, err := js.StreamInfo(stream)
+		// Multi-token subjects such as BUILDS.logs.<deploymentID> must match
+		subjects := []string{stream.name + ".>"}
+
+		info, err := js.StreamInfo(stream.name)
 		if err != nil {
 			// Stream doesn't exist, create it
 			_, err = js.AddStream(&nats.StreamConfig{
-				Name:     stream,
-				Subjects: []string{stream + ".*"},
+				Name:     stream.name,
+				Subjects: subjects,
+				MaxAge:   stream.maxAge,
 			})
 			if err != nil {
 				return nil, err
 			}
+			continue
+		}
+
+		// Upgrade streams created with the older single-token wildcard or
+		// without a retention limit
+		config := info.Config
+		if len(config.Subjects) != 1 || config.Subjects[0] != subjects[0] || config.MaxAge != stream.maxAge {
+			config.Subjects = subjects
+			config.MaxAge = stream.maxAge
+			if _, err := js.UpdateStream(&config); err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -67,6 +93,29 @@ func (q *Queue) Subscribe(subject string, handler func([]byte)) (*nats.Subscript
 	})
 }
 
+// QueueSubscribe delivers each message to only one member of the durable
+// queue group, starting with messages published after the group was created
+func (q *Queue) QueueSubscribe(subject, group string, handler func([]byte)) (*nats.Subscription, error) {
+	return q.js.QueueSubscribe(subject, group, func(msg *nats.Msg) {
+		handler(msg.Data)
+		msg.Ack()
+	}, nats.Durable(group), nats.DeliverNew())
+}
+
+// Request sends a core NATS request, which no stream captures, and decodes
+// the reply into reply
+func (q *Queue) Request(subject string, data interface{}, reply interface{}, timeout time.Duration) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	msg, err := q.conn.Request(subject, payload, timeout)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(msg.Data, reply)
+}
+
 func (q *Queue) Close() error {
 	q.conn.Close()
 	return nil
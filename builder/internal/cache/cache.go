@@ -0,0 +1,433 @@
+package cache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache keeps build caches per project under one directory:
+//
+//	<dir>/<projectID>/tools/<name>       package manager and compiler caches, used in place
+//	<dir>/<projectID>/<entry>-<key>.tgz  snapshots of project directories such as node_modules
+//
+// Projects and snapshots are evicted least recently used first once the
+// size limits are exceeded. Restoring, saving and evicting the caches of a
+// project are serialized, so concurrent builds of the project and eviction
+// never see an entry half written or half removed.
+type Cache struct {
+	dir             string
+	maxProjectBytes int64
+	maxTotalBytes   int64
+
+	mu sync.Mutex // serializes evictions
+
+	locksMu sync.Mutex
+	locks   map[string]*sync.Mutex // by project directory
+}
+
+// Entry is a directory of the project that is kept between builds
+type Entry struct {
+	Path string // relative to the project, e.g. node_modules
+	Key  string // e.g. the lockfile hash; "" keeps only the latest snapshot
+}
+
+func New(dir string, maxProjectBytes, maxTotalBytes int64) *Cache {
+	return &Cache{
+		dir:             dir,
+		maxProjectBytes: maxProjectBytes,
+		maxTotalBytes:   maxTotalBytes,
+		locks:           map[string]*sync.Mutex{},
+	}
+}
+
+// lock takes the lock of a project's caches and returns its release
+func (c *Cache) lock(projectDir string) func() {
+	c.locksMu.Lock()
+	l, ok := c.locks[projectDir]
+	if !ok {
+		l = &sync.Mutex{}
+		c.locks[projectDir] = l
+	}
+	c.locksMu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// ToolDir returns a persistent directory for a tool's own cache, e.g. the
+// npm cache or GOMODCACHE
+func (c *Cache) ToolDir(projectID, name string) (string, error) {
+	defer c.lock(c.projectDir(projectID))()
+
+	dir := filepath.Join(c.projectDir(projectID), "tools", name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	c.touch(projectID)
+	return dir, nil
+}
+
+// Restore unpacks the snapshot of entry into the project. It reports false
+// when there is none for the entry's key.
+func (c *Cache) Restore(projectID, projectPath string, entry Entry) (bool, error) {
+	defer c.lock(c.projectDir(projectID))()
+
+	archive := c.archivePath(projectID, entry)
+	if _, err := os.Stat(archive); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	target := filepath.Join(projectPath, filepath.FromSlash(entry.Path))
+	if err := os.RemoveAll(target); err != nil {
+		return false, err
+	}
+	if err := extract(archive, target); err != nil {
+		// A broken snapshot is only a miss
+		os.Remove(archive)
+		os.RemoveAll(target)
+		return false, err
+	}
+
+	now := time.Now()
+	os.Chtimes(archive, now, now)
+	c.touch(projectID)
+	return true, nil
+}
+
+// Save snapshots entry from the project, replacing older snapshots of the
+// same entry
+func (c *Cache) Save(projectID, projectPath string, entry Entry) error {
+	saved, err := c.save(projectID, projectPath, entry)
+	if err != nil || !saved {
+		return err
+	}
+	return c.Evict()
+}
+
+// save writes the snapshot and reports whether it did
+func (c *Cache) save(projectID, projectPath string, entry Entry) (bool, error) {
+	defer c.lock(c.projectDir(projectID))()
+
+	source := filepath.Join(projectPath, filepath.FromSlash(entry.Path))
+	if info, err := os.Stat(source); err != nil || !info.IsDir() {
+		return false, nil
+	}
+
+	archive := c.archivePath(projectID, entry)
+	if entry.Key != "" {
+		if _, err := os.Stat(archive); err == nil {
+			// Same key, same content
+			return false, nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(archive), 0755); err != nil {
+		return false, err
+	}
+	file, err := os.CreateTemp(filepath.Dir(archive), entryName(entry)+"-*.tmp")
+	if err != nil {
+		return false, err
+	}
+	file.Close()
+	tmp := file.Name()
+	if err := compress(source, tmp); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+
+	old, _ := filepath.Glob(filepath.Join(filepath.Dir(archive), entryName(entry)+"-*.tgz"))
+	for _, path := range old {
+		os.Remove(path)
+	}
+	if err := os.Rename(tmp, archive); err != nil {
+		return false, err
+	}
+
+	c.touch(projectID)
+	return true, nil
+}
+
+// Clear drops everything cached for a project
+func (c *Cache) Clear(projectID string) error {
+	dir := c.projectDir(projectID)
+	defer c.lock(dir)()
+	return os.RemoveAll(dir)
+}
+
+// Evict enforces the size limits: oldest snapshots first within a project
+// over its limit, then whole projects by last use
+func (c *Cache) Evict() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	projects, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type usage struct {
+		dir      string
+		size     int64
+		lastUsed time.Time
+	}
+	var all []usage
+	var total int64
+
+	for _, project := range projects {
+		if !project.IsDir() {
+			continue
+		}
+		dir := filepath.Join(c.dir, project.Name())
+
+		unlock := c.lock(dir)
+		size := dirSize(dir)
+		if c.maxProjectBytes > 0 && size > c.maxProjectBytes {
+			size = c.shrinkProject(dir, size)
+		}
+		unlock()
+
+		info, err := project.Info()
+		if err != nil {
+			continue
+		}
+		all = append(all, usage{dir: dir, size: size, lastUsed: info.ModTime()})
+		total += size
+	}
+
+	if c.maxTotalBytes <= 0 || total <= c.maxTotalBytes {
+		return nil
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].lastUsed.Before(all[j].lastUsed) })
+	for _, project := range all {
+		if total <= c.maxTotalBytes {
+			break
+		}
+		unlock := c.lock(project.dir)
+		err := os.RemoveAll(project.dir)
+		unlock()
+		if err != nil {
+			return err
+		}
+		total -= project.size
+	}
+	return nil
+}
+
+// shrinkProject removes the oldest snapshots, then the tool caches, until
+// the project fits its limit
+func (c *Cache) shrinkProject(dir string, size int64) int64 {
+	archives, _ := filepath.Glob(filepath.Join(dir, "*.tgz"))
+	sort.Slice(archives, func(i, j int) bool { return modTime(archives[i]).Before(modTime(archives[j])) })
+
+	for _, archive := range archives {
+		if size <= c.maxProjectBytes {
+			return size
+		}
+		if info, err := os.Stat(archive); err == nil && os.Remove(archive) == nil {
+			size -= info.Size()
+		}
+	}
+
+	if size > c.maxProjectBytes {
+		tools := filepath.Join(dir, "tools")
+		size -= dirSize(tools)
+		os.RemoveAll(tools)
+	}
+	return size
+}
+
+func (c *Cache) projectDir(projectID string) string {
+	return filepath.Join(c.dir, filepath.Base(projectID))
+}
+
+func (c *Cache) archivePath(projectID string, entry Entry) string {
+	key := entry.Key
+	if key == "" {
+		key = "latest"
+	}
+	return filepath.Join(c.projectDir(projectID), fmt.Sprintf("%s-%s.tgz", entryName(entry), key))
+}
+
+// touch marks the project as used for eviction
+func (c *Cache) touch(projectID string) {
+	now := time.Now()
+	os.Chtimes(c.projectDir(projectID), now, now)
+}
+
+// entryName turns an entry path like .next/cache into a file name
+func entryName(entry Entry) string {
+	return strings.NewReplacer("/", "_", ".", "_").Replace(entry.Path)
+}
+
+// HashFiles returns a key over the contents of the files that exist in dir,
+// or "" when none does
+func HashFiles(dir string, names ...string) string {
+	hash := sha256.New()
+	found := false
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		found = true
+		fmt.Fprintf(hash, "%s\x00", name)
+		hash.Write(data)
+	}
+	if !found {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func compress(source, archive string) error {
+	file, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewWriterLevel(file, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		// node_modules/.bin is made of symlinks
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func extract(archive, target string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(target, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(path, filepath.Clean(target)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path %q in cache archive", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, os.FileMode(header.Mode)|0700); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -1,55 +1,419 @@
 package detector
 
 import (
+	"bufio"
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 )
 
+// Result describes how a project is built and run. Commands are the defaults
+// for the detected framework; project settings override them.
+type Result struct {
+	Framework      string  `json:"framework"`
+	PackageManager string  `json:"package_manager,omitempty"`
+	InstallCommand string  `json:"install_command,omitempty"`
+	BuildCommand   string  `json:"build_command,omitempty"`
+	OutputDir      string  `json:"output_dir,omitempty"`
+	StartCommand   string  `json:"start_command,omitempty"`
+	Port           int     `json:"port"`
+	Confidence     float64 `json:"confidence"` // 0-1, how sure detection is of the framework
+}
+
 // Detect mendeteksi framework berdasarkan file yang ada
 func Detect(projectPath string) string {
-	// Check Next.js
-	if fileExists(filepath.Join(projectPath, "next.config.js")) ||
-		fileExists(filepath.Join(projectPath, "next.config.mjs")) {
-		return "nextjs"
+	return Analyze(projectPath).Framework
+}
+
+// Analyze inspects package.json dependencies and scripts, lockfiles and
+// language manifests to find the framework and its defaults
+func Analyze(projectPath string) *Result {
+	p := newProject(projectPath)
+	framework, confidence := p.framework()
+	result := p.defaults(framework)
+	result.Confidence = confidence
+	return result
+}
+
+// AnalyzeAs fills in the defaults for a framework chosen by the user
+func AnalyzeAs(projectPath, framework string) *Result {
+	result := newProject(projectPath).defaults(framework)
+	result.Confidence = 1
+	return result
+}
+
+type packageJSON struct {
+	Main            string            `json:"main"`
+	PackageManager  string            `json:"packageManager"`
+	Scripts         map[string]string `json:"scripts"`
+	Dependencies    map[string]string `json:"dependencies"`
+	DevDependencies map[string]string `json:"devDependencies"`
+}
+
+type project struct {
+	path string
+	pkg  *packageJSON // nil without a package.json
+}
+
+func newProject(path string) *project {
+	p := &project{path: path}
+	if data, err := os.ReadFile(p.file("package.json")); err == nil {
+		var pkg packageJSON
+		if json.Unmarshal(data, &pkg) == nil {
+			p.pkg = &pkg
+		} else {
+			// Still a Node project, just without usable metadata
+			p.pkg = &packageJSON{}
+		}
+	}
+	return p
+}
+
+// framework picks the most specific match, from framework dependencies down
+// to bare language manifests
+func (p *project) framework() (string, float64) {
+	if p.pkg != nil {
+		switch {
+		case p.dependsOn("next"):
+			return "nextjs", 0.95
+		case p.dependsOn("nuxt", "nuxt3"):
+			return "nuxtjs", 0.95
+		case p.dependsOnPrefix("@remix-run/"):
+			return "remix", 0.95
+		case p.dependsOn("@sveltejs/kit"):
+			return "sveltekit", 0.95
+		case p.dependsOn("astro"):
+			return "astro", 0.95
+		case p.dependsOn("vite"):
+			return "vite", 0.9
+		case p.dependsOn("react-scripts"):
+			// Create React App builds a static site into build/
+			return "static", 0.8
+		}
+	}
+
+	// Config files without the matching dependency, e.g. a workspace that
+	// hoists dependencies to the repository root
+	switch {
+	case p.exists("next.config.js", "next.config.mjs", "next.config.ts"):
+		return "nextjs", 0.8
+	case p.exists("nuxt.config.js", "nuxt.config.ts"):
+		return "nuxtjs", 0.8
+	case p.exists("svelte.config.js"):
+		return "sveltekit", 0.7
+	case p.exists("astro.config.mjs", "astro.config.ts"):
+		return "astro", 0.7
+	case p.exists("vite.config.js", "vite.config.ts", "vite.config.mjs"):
+		return "vite", 0.7
+	}
+
+	switch {
+	case p.exists("go.mod"):
+		return "go", 0.9
+	case p.exists("Cargo.toml"):
+		return "rust", 0.9
+	case p.exists("manage.py"):
+		return "django", 0.9
+	case p.pythonDependsOn("django"):
+		return "django", 0.8
+	case p.pythonDependsOn("flask"):
+		return "flask", 0.8
+	case p.exists("Gemfile") && p.fileContains("Gemfile", "rails"):
+		return "rails", 0.9
+	case p.exists("composer.json"):
+		return "php", 0.9
+	case p.pkg != nil && p.packageManager() == "bun":
+		return "bun", 0.8
+	case p.pkg != nil:
+		return "nodejs", 0.7
+	case p.exists("index.html"):
+		return "static", 0.6
+	}
+
+	// Nothing recognisable, serve the files as they are
+	return "static", 0.1
+}
+
+// defaults returns the package manager, commands and port of framework
+func (p *project) defaults(framework string) *Result {
+	result := &Result{Framework: framework}
+
+	switch framework {
+	case "nextjs", "nuxtjs", "remix", "sveltekit", "astro", "vite", "nodejs", "bun", "static":
+		if p.pkg == nil {
+			break
+		}
+		pm := p.packageManager()
+		if framework == "bun" {
+			pm = "bun"
+		}
+		result.PackageManager = pm
+		result.InstallCommand = p.installCommand(pm)
+		if _, ok := p.pkg.Scripts["build"]; ok {
+			result.BuildCommand = pm + " run build"
+		}
 	}
 
-	// Check Nuxt
-	if fileExists(filepath.Join(projectPath, "nuxt.config.js")) ||
-		fileExists(filepath.Join(projectPath, "nuxt.config.ts")) {
-		return "nuxtjs"
+	switch framework {
+	case "nextjs":
+		result.OutputDir = ".next"
+		result.StartCommand = "npm start"
+		result.Port = 3000
+	case "nuxtjs":
+		result.OutputDir = ".output"
+		result.StartCommand = "node .output/server/index.mjs"
+		result.Port = 3000
+	case "remix":
+		result.OutputDir = "build"
+		result.StartCommand = "npm start"
+		result.Port = 3000
+	case "sveltekit":
+		// adapter-node
+		result.OutputDir = "build"
+		result.StartCommand = "node build"
+		result.Port = 3000
+	case "astro", "vite":
+		result.OutputDir = "dist"
+		result.Port = 8080
+	case "nodejs", "bun":
+		result.StartCommand = p.nodeStartCommand(result.PackageManager)
+		result.Port = 3000
+	case "go":
+		result.PackageManager = "go"
+		result.BuildCommand = "go build -o main ."
+		result.StartCommand = "./main"
+		result.Port = 8080
+	case "rust":
+		result.PackageManager = "cargo"
+		result.BuildCommand = "cargo build --release"
+		result.StartCommand = "./target/release/" + p.cargoPackageName()
+		result.Port = 8080
+	case "django":
+		result.PackageManager, result.InstallCommand = p.pythonInstall()
+		result.StartCommand = "gunicorn --bind 0.0.0.0:8000 " + p.djangoWSGIModule()
+		result.Port = 8000
+	case "flask":
+		result.PackageManager, result.InstallCommand = p.pythonInstall()
+		result.StartCommand = "gunicorn --bind 0.0.0.0:8000 " + p.flaskApp()
+		result.Port = 8000
+	case "rails":
+		result.PackageManager = "bundler"
+		result.InstallCommand = "bundle install"
+		if p.exists("app/assets") {
+			result.BuildCommand = "bundle exec rails assets:precompile"
+		}
+		result.StartCommand = "bundle exec rails server -b 0.0.0.0 -p 3000"
+		result.Port = 3000
+	case "php":
+		result.PackageManager = "composer"
+		result.InstallCommand = "composer install --no-dev --optimize-autoloader"
+		result.Port = 8080
+	default:
+		// static
+		result.OutputDir = "."
+		if result.BuildCommand != "" {
+			result.OutputDir = "dist"
+			if p.dependsOn("react-scripts") {
+				result.OutputDir = "build"
+			}
+		}
+		result.Port = 8080
 	}
 
-	// Check Go
-	if fileExists(filepath.Join(projectPath, "go.mod")) {
-		return "go"
+	return result
+}
+
+func (p *project) dependsOn(names ...string) bool {
+	if p.pkg == nil {
+		return false
+	}
+	for _, name := range names {
+		if _, ok := p.pkg.Dependencies[name]; ok {
+			return true
+		}
+		if _, ok := p.pkg.DevDependencies[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *project) dependsOnPrefix(prefix string) bool {
+	if p.pkg == nil {
+		return false
 	}
+	for _, deps := range []map[string]string{p.pkg.Dependencies, p.pkg.DevDependencies} {
+		for name := range deps {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	// Check PHP/Laravel
-	if fileExists(filepath.Join(projectPath, "composer.json")) {
-		return "php"
+// packageManager prefers the packageManager field, then the lockfile
+func (p *project) packageManager() string {
+	if p.pkg != nil && p.pkg.PackageManager != "" {
+		name := strings.SplitN(p.pkg.PackageManager, "@", 2)[0]
+		switch name {
+		case "npm", "pnpm", "yarn", "bun":
+			return name
+		}
 	}
 
-	// Check Bun
-	if fileExists(filepath.Join(projectPath, "bun.lockb")) {
+	switch {
+	case p.exists("pnpm-lock.yaml"):
+		return "pnpm"
+	case p.exists("yarn.lock"):
+		return "yarn"
+	case p.exists("bun.lockb", "bun.lock"):
 		return "bun"
+	default:
+		return "npm"
 	}
+}
+
+// installCommand installs exactly the locked versions when there is a lockfile
+func (p *project) installCommand(pm string) string {
+	switch pm {
+	case "pnpm":
+		if p.exists("pnpm-lock.yaml") {
+			return "pnpm install --frozen-lockfile"
+		}
+		return "pnpm install"
+	case "yarn":
+		if p.exists("yarn.lock") {
+			return "yarn install --frozen-lockfile"
+		}
+		return "yarn install"
+	case "bun":
+		return "bun install"
+	default:
+		if p.exists("package-lock.json") {
+			return "npm ci"
+		}
+		return "npm install"
+	}
+}
+
+func (p *project) nodeStartCommand(pm string) string {
+	if p.pkg != nil {
+		if _, ok := p.pkg.Scripts["start"]; ok {
+			if pm == "bun" {
+				return "bun run start"
+			}
+			return "npm start"
+		}
+	}
+
+	runtime := "node"
+	if pm == "bun" {
+		runtime = "bun"
+	}
+	if p.pkg != nil && p.pkg.Main != "" {
+		return runtime + " " + p.pkg.Main
+	}
+	for _, entry := range []string{"index.js", "server.js", "app.js", "main.js", "index.ts"} {
+		if p.exists(entry) {
+			return runtime + " " + entry
+		}
+	}
+	return runtime + " index.js"
+}
+
+// pythonInstall returns the package manager and install command of a Python
+// project
+func (p *project) pythonInstall() (string, string) {
+	switch {
+	case p.exists("poetry.lock"):
+		return "poetry", "pip install poetry && poetry config virtualenvs.create false && poetry install --no-root --only main"
+	case p.exists("requirements.txt"):
+		return "pip", "pip install -r requirements.txt"
+	case p.exists("pyproject.toml"):
+		return "pip", "pip install ."
+	default:
+		return "pip", ""
+	}
+}
+
+func (p *project) pythonDependsOn(name string) bool {
+	for _, manifest := range []string{"requirements.txt", "pyproject.toml", "Pipfile"} {
+		if p.fileContains(manifest, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// djangoWSGIModule finds the settings package that holds wsgi.py
+func (p *project) djangoWSGIModule() string {
+	matches, _ := filepath.Glob(filepath.Join(p.path, "*", "wsgi.py"))
+	if len(matches) > 0 {
+		return filepath.Base(filepath.Dir(matches[0])) + ".wsgi"
+	}
+	return "wsgi"
+}
+
+func (p *project) flaskApp() string {
+	for _, module := range []string{"app", "wsgi", "main", "server"} {
+		if p.exists(module + ".py") {
+			return module + ":app"
+		}
+	}
+	return "app:app"
+}
+
+var cargoNamePattern = regexp.MustCompile(`^name\s*=\s*"([^"]+)"`)
 
-	// Check Node.js
-	if fileExists(filepath.Join(projectPath, "package.json")) {
-		return "nodejs"
+// cargoPackageName reads the binary name from the [package] table
+func (p *project) cargoPackageName() string {
+	file, err := os.Open(p.file("Cargo.toml"))
+	if err != nil {
+		return "app"
 	}
+	defer file.Close()
 
-	// Check static site
-	if fileExists(filepath.Join(projectPath, "index.html")) {
-		return "static"
+	inPackage := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inPackage = line == "[package]"
+			continue
+		}
+		if m := cargoNamePattern.FindStringSubmatch(line); inPackage && m != nil {
+			return m[1]
+		}
 	}
+	return "app"
+}
+
+func (p *project) file(name string) string {
+	return filepath.Join(p.path, filepath.FromSlash(name))
+}
+
+// exists reports whether any of the files exists
+func (p *project) exists(names ...string) bool {
+	for _, name := range names {
+		if fileExists(p.file(name)) {
+			return true
+		}
+	}
+	return false
+}
 
-	// Default to static
-	return "static"
+func (p *project) fileContains(name, needle string) bool {
+	data, err := os.ReadFile(p.file(name))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(string(data)), needle)
 }
 
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
 }
-
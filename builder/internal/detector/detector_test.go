@@ -0,0 +1,169 @@
+package detector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  Result
+	}{
+		{
+			name: "next with pnpm",
+			files: map[string]string{
+				"package.json":   `{"scripts": {"build": "next build"}, "dependencies": {"next": "14.0.0"}}`,
+				"pnpm-lock.yaml": "",
+			},
+			want: Result{Framework: "nextjs", PackageManager: "pnpm", InstallCommand: "pnpm install --frozen-lockfile", BuildCommand: "pnpm run build", OutputDir: ".next", StartCommand: "npm start", Port: 3000, Confidence: 0.95},
+		},
+		{
+			name: "vite with yarn",
+			files: map[string]string{
+				"package.json": `{"scripts": {"build": "vite build"}, "devDependencies": {"vite": "5.0.0"}}`,
+				"yarn.lock":    "",
+			},
+			want: Result{Framework: "vite", PackageManager: "yarn", InstallCommand: "yarn install --frozen-lockfile", BuildCommand: "yarn run build", OutputDir: "dist", Port: 8080, Confidence: 0.9},
+		},
+		{
+			name: "remix by package prefix",
+			files: map[string]string{
+				"package.json": `{"dependencies": {"@remix-run/node": "2.0.0"}}`,
+			},
+			want: Result{Framework: "remix", PackageManager: "npm", InstallCommand: "npm install", OutputDir: "build", StartCommand: "npm start", Port: 3000, Confidence: 0.95},
+		},
+		{
+			name: "packageManager field wins over the lockfile",
+			files: map[string]string{
+				"package.json":      `{"packageManager": "pnpm@8.15.0", "scripts": {"start": "node server.js"}}`,
+				"package-lock.json": "",
+			},
+			want: Result{Framework: "nodejs", PackageManager: "pnpm", InstallCommand: "pnpm install", StartCommand: "npm start", Port: 3000, Confidence: 0.7},
+		},
+		{
+			name: "node with npm lockfile and main",
+			files: map[string]string{
+				"package.json":      `{"main": "src/app.js"}`,
+				"package-lock.json": "",
+			},
+			want: Result{Framework: "nodejs", PackageManager: "npm", InstallCommand: "npm ci", StartCommand: "node src/app.js", Port: 3000, Confidence: 0.7},
+		},
+		{
+			name: "bun lockfile",
+			files: map[string]string{
+				"package.json": `{}`,
+				"bun.lockb":    "",
+				"index.ts":     "",
+			},
+			want: Result{Framework: "bun", PackageManager: "bun", InstallCommand: "bun install", StartCommand: "bun index.ts", Port: 3000, Confidence: 0.8},
+		},
+		{
+			name: "create react app",
+			files: map[string]string{
+				"package.json": `{"scripts": {"build": "react-scripts build"}, "dependencies": {"react-scripts": "5.0.1"}}`,
+			},
+			want: Result{Framework: "static", PackageManager: "npm", InstallCommand: "npm install", BuildCommand: "npm run build", OutputDir: "build", Port: 8080, Confidence: 0.8},
+		},
+		{
+			name: "config file without the dependency",
+			files: map[string]string{
+				"astro.config.mjs": "",
+			},
+			want: Result{Framework: "astro", OutputDir: "dist", Port: 8080, Confidence: 0.7},
+		},
+		{
+			name: "go",
+			files: map[string]string{
+				"go.mod": "module example.com/app",
+			},
+			want: Result{Framework: "go", PackageManager: "go", BuildCommand: "go build -o main .", StartCommand: "./main", Port: 8080, Confidence: 0.9},
+		},
+		{
+			name: "rust",
+			files: map[string]string{
+				"Cargo.toml": "[package]\nname = \"api\"\n\n[dependencies]\nname = \"ignored\"\n",
+			},
+			want: Result{Framework: "rust", PackageManager: "cargo", BuildCommand: "cargo build --release", StartCommand: "./target/release/api", Port: 8080, Confidence: 0.9},
+		},
+		{
+			name: "django",
+			files: map[string]string{
+				"manage.py":        "",
+				"requirements.txt": "Django==5.0\ngunicorn\n",
+				"mysite/wsgi.py":   "",
+			},
+			want: Result{Framework: "django", PackageManager: "pip", InstallCommand: "pip install -r requirements.txt", StartCommand: "gunicorn --bind 0.0.0.0:8000 mysite.wsgi", Port: 8000, Confidence: 0.9},
+		},
+		{
+			name: "flask with poetry",
+			files: map[string]string{
+				"pyproject.toml": "[tool.poetry.dependencies]\nFlask = \"^3.0\"\n",
+				"poetry.lock":    "",
+				"main.py":        "",
+			},
+			want: Result{Framework: "flask", PackageManager: "poetry", InstallCommand: "pip install poetry && poetry config virtualenvs.create false && poetry install --no-root --only main", StartCommand: "gunicorn --bind 0.0.0.0:8000 main:app", Port: 8000, Confidence: 0.8},
+		},
+		{
+			name: "rails",
+			files: map[string]string{
+				"Gemfile":          "gem 'rails', '~> 7.1'\n",
+				"app/assets/.keep": "",
+			},
+			want: Result{Framework: "rails", PackageManager: "bundler", InstallCommand: "bundle install", BuildCommand: "bundle exec rails assets:precompile", StartCommand: "bundle exec rails server -b 0.0.0.0 -p 3000", Port: 3000, Confidence: 0.9},
+		},
+		{
+			name: "php",
+			files: map[string]string{
+				"composer.json": "{}",
+			},
+			want: Result{Framework: "php", PackageManager: "composer", InstallCommand: "composer install --no-dev --optimize-autoloader", Port: 8080, Confidence: 0.9},
+		},
+		{
+			name: "plain html",
+			files: map[string]string{
+				"index.html": "",
+			},
+			want: Result{Framework: "static", OutputDir: ".", Port: 8080, Confidence: 0.6},
+		},
+		{
+			name:  "nothing recognisable",
+			files: map[string]string{"README.md": ""},
+			want:  Result{Framework: "static", OutputDir: ".", Port: 8080, Confidence: 0.1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(dir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := Analyze(dir); *got != tt.want {
+				t.Errorf("Analyze() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeAs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"scripts": {"build": "vite build"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := AnalyzeAs(dir, "vite")
+	want := Result{Framework: "vite", PackageManager: "npm", InstallCommand: "npm install", BuildCommand: "npm run build", OutputDir: "dist", Port: 8080, Confidence: 1}
+	if *got != want {
+		t.Errorf("AnalyzeAs() = %+v, want %+v", *got, want)
+	}
+}
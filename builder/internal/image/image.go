@@ -0,0 +1,323 @@
+// Package image generates the Dockerfiles of projects that bring none.
+//
+// The runner has already installed and built the project on the builder, so
+// the images package its output instead of building it again: Node and Bun
+// apps get their production dependencies in a stage of their own, keyed by
+// the lockfile, and every image runs as an unprivileged user. Only Python,
+// Ruby and Rust, whose toolchains are not on the builder, build in the image.
+package image
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/dejavu/builder/internal/detector"
+)
+
+//go:embed templates/*.Dockerfile
+var files embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"exec": execForm,
+	"join": strings.Join,
+}).ParseFS(files, "templates/*.Dockerfile"))
+
+// The port unprivileged nginx listens on
+const staticPort = 8080
+
+// Image is a generated Dockerfile and the ignores of its build context
+type Image struct {
+	Dockerfile   string
+	Dockerignore string
+	Port         int // the port the image listens on
+}
+
+// data is what the templates see
+type data struct {
+	*detector.Result
+
+	Deps        bool     // production dependencies are installed in their own stage
+	DepsFiles   []string // copied into that stage ahead of the sources
+	DepsInstall string
+	Corepack    bool // the package manager ships with Node through corepack
+
+	AppDir string // where Next.js put server.js in its standalone output
+	Public bool   // the project has a public directory
+	Binary string // the executable of compiled apps
+}
+
+// Generate renders the Dockerfile for the analysed project, whose build
+// output must already be in projectPath
+func Generate(projectPath string, analysis *detector.Result) (*Image, error) {
+	result := *analysis
+	d := &data{Result: &result, Public: isDir(projectPath, "public")}
+	ignore := []string{".git"}
+	// The dependency stage installs node_modules afresh
+	nodeDeps := func() {
+		if d.nodeDeps(projectPath) {
+			ignore = append(ignore, "**/node_modules")
+		}
+	}
+
+	name := "static"
+	switch analysis.Framework {
+	case "nextjs":
+		if appDir, ok := nextStandalone(projectPath, analysis.OutputDir); ok {
+			name, d.AppDir = "nextjs-standalone", appDir
+		} else {
+			name = "node"
+			nodeDeps()
+		}
+		ignore = append(ignore, analysis.OutputDir+"/cache")
+	case "nuxtjs":
+		// nuxt generate leaves only the static site
+		if exists(projectPath, analysis.OutputDir+"/server/index.mjs") {
+			name = "nuxt"
+		} else {
+			d.OutputDir = analysis.OutputDir + "/public"
+		}
+	case "nodejs", "remix", "sveltekit":
+		name = "node"
+		nodeDeps()
+	case "bun":
+		if binary := bunBinary(projectPath); binary != "" {
+			name, d.Binary = "bun-compiled", binary
+		} else {
+			name = "bun"
+			nodeDeps()
+		}
+	case "go":
+		name = "go"
+		d.Binary = strings.TrimPrefix(analysis.StartCommand, "./")
+	case "rust":
+		name = "rust"
+		d.Binary = filepath.Base(analysis.StartCommand)
+		ignore = append(ignore, "target")
+	case "django", "flask":
+		name = "python"
+		d.pythonDeps(projectPath)
+		ignore = append(ignore, "**/__pycache__", ".venv")
+	case "rails":
+		name = "rails"
+		d.DepsFiles = existing(projectPath, "Gemfile", "Gemfile.lock")
+		ignore = append(ignore, "log", "tmp")
+	case "php":
+		name = "php"
+	}
+	if name == "static" {
+		d.Port = staticPort
+	}
+
+	var dockerfile bytes.Buffer
+	if err := templates.ExecuteTemplate(&dockerfile, name+".Dockerfile", d); err != nil {
+		return nil, err
+	}
+	return &Image{
+		Dockerfile:   dockerfile.String(),
+		Dockerignore: strings.Join(ignore, "\n") + "\n",
+		Port:         d.Port,
+	}, nil
+}
+
+// nodeDeps sets up the dependency stage: the production dependencies are
+// installed from the lockfile, so the layer is reused until it changes.
+// Projects the stage cannot reproduce keep the node_modules the runner
+// installed instead: custom install commands, workspaces, Yarn Plug'n'Play,
+// install scripts and generated clients, registries that authenticate with
+// the build-time env vars, which the stage does not have, and projects
+// without a lockfile of their own. It reports whether the stage is used.
+func (d *data) nodeDeps(projectPath string) bool {
+	if d.InstallCommand != detector.AnalyzeAs(projectPath, d.Framework).InstallCommand {
+		return false
+	}
+	if exists(projectPath, "pnpm-workspace.yaml", ".yarnrc.yml") || hasWorkspaces(projectPath) {
+		return false
+	}
+	if hasInstallScripts(projectPath) || npmrcUsesEnv(projectPath) {
+		return false
+	}
+
+	var lockfile, install string
+	switch d.PackageManager {
+	case "pnpm":
+		lockfile, install = "pnpm-lock.yaml", "pnpm install --prod --frozen-lockfile"
+		d.Corepack = true
+	case "yarn":
+		lockfile, install = "yarn.lock", "yarn install --production --frozen-lockfile"
+		d.Corepack = true
+	case "bun":
+		lockfile, install = "bun.lockb", "bun install --production --frozen-lockfile"
+		if !exists(projectPath, lockfile) {
+			lockfile = "bun.lock"
+		}
+	default:
+		lockfile, install = "package-lock.json", "npm ci --omit=dev"
+	}
+	if !exists(projectPath, lockfile) {
+		d.Corepack = false
+		return false
+	}
+
+	d.Deps = true
+	d.DepsFiles = append([]string{"package.json", lockfile}, existing(projectPath, ".npmrc")...)
+	d.DepsInstall = install
+	return true
+}
+
+// pythonDeps installs the requirements ahead of the sources when the install
+// command needs nothing but the manifests
+func (d *data) pythonDeps(projectPath string) {
+	switch d.InstallCommand {
+	case "pip install -r requirements.txt":
+		d.DepsFiles = []string{"requirements.txt"}
+	case detector.AnalyzeAs(projectPath, d.Framework).InstallCommand:
+		if d.PackageManager == "poetry" {
+			d.DepsFiles = []string{"pyproject.toml", "poetry.lock"}
+		}
+	}
+	d.Deps = len(d.DepsFiles) > 0
+}
+
+// nextStandalone reports whether the build has standalone output
+// (output: 'standalone') and where server.js is in it: monorepo apps are
+// nested the way they are in the repository
+func nextStandalone(projectPath, outputDir string) (string, bool) {
+	root := filepath.Join(projectPath, filepath.FromSlash(outputDir), "standalone")
+	if !isDir(root, ".") {
+		return "", false
+	}
+
+	appDir, found := "", false
+	filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && entry.Name() == "node_modules" {
+			return filepath.SkipDir
+		}
+		if entry.Name() != "server.js" {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, filepath.Dir(path))
+		if rel == "." {
+			rel = ""
+		} else {
+			rel = filepath.ToSlash(rel) + "/"
+		}
+		if !found || len(rel) < len(appDir) {
+			appDir, found = rel, true
+		}
+		return nil
+	})
+	return appDir, found
+}
+
+var bunCompilePattern = regexp.MustCompile(`--outfile[= ]+(\S+)`)
+
+// bunBinary returns the executable the build script compiles with
+// bun build --compile, "" when it compiles none
+func bunBinary(projectPath string) string {
+	data, err := os.ReadFile(filepath.Join(projectPath, "package.json"))
+	if err != nil {
+		return ""
+	}
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	if json.Unmarshal(data, &pkg) != nil {
+		return ""
+	}
+
+	script := pkg.Scripts["build"]
+	if !strings.Contains(script, "--compile") {
+		return ""
+	}
+	m := bunCompilePattern.FindStringSubmatch(script)
+	if m == nil || !exists(projectPath, m[1]) {
+		return ""
+	}
+	return filepath.ToSlash(filepath.Clean(m[1]))
+}
+
+// hasWorkspaces reports whether package.json declares workspaces, whose
+// packages the dependency stage would not have
+func hasWorkspaces(projectPath string) bool {
+	data, err := os.ReadFile(filepath.Join(projectPath, "package.json"))
+	if err != nil {
+		return false
+	}
+	var pkg struct {
+		Workspaces json.RawMessage `json:"workspaces"`
+	}
+	return json.Unmarshal(data, &pkg) == nil && len(pkg.Workspaces) > 0
+}
+
+// hasInstallScripts reports whether installing the project changes
+// node_modules beyond the packages: lifecycle scripts of the project, which
+// may need its sources, and the Prisma client, which prisma generate writes
+// into node_modules and a fresh install replaces with a stub
+func hasInstallScripts(projectPath string) bool {
+	data, err := os.ReadFile(filepath.Join(projectPath, "package.json"))
+	if err != nil {
+		return false
+	}
+	var pkg struct {
+		Scripts      map[string]string `json:"scripts"`
+		Dependencies map[string]string `json:"dependencies"`
+	}
+	if json.Unmarshal(data, &pkg) != nil {
+		return false
+	}
+
+	for _, script := range []string{"preinstall", "install", "postinstall", "prepare"} {
+		if _, ok := pkg.Scripts[script]; ok {
+			return true
+		}
+	}
+	_, prisma := pkg.Dependencies["@prisma/client"]
+	return prisma
+}
+
+// npmrcUsesEnv reports whether .npmrc reads environment variables, e.g. the
+// ${NPM_TOKEN} of a private registry
+func npmrcUsesEnv(projectPath string) bool {
+	data, err := os.ReadFile(filepath.Join(projectPath, ".npmrc"))
+	return err == nil && strings.Contains(string(data), "${")
+}
+
+// execForm is the exec-form CMD of command. Commands that need a shell run
+// through one; the rest run directly, so they get the container's signals.
+func execForm(command string) string {
+	args := strings.Fields(command)
+	if strings.ContainsAny(command, "&|;<>()$`'\"\\*?~=") {
+		args = []string{"sh", "-c", command}
+	}
+	data, _ := json.Marshal(args)
+	return string(data)
+}
+
+func exists(dir string, names ...string) bool {
+	return len(existing(dir, names...)) > 0
+}
+
+// existing returns the names of the files that exist in dir
+func existing(dir string, names ...string) []string {
+	var found []string
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err == nil {
+			found = append(found, name)
+		}
+	}
+	return found
+}
+
+func isDir(dir, name string) bool {
+	info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+	return err == nil && info.IsDir()
+}
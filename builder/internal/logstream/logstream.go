@@ -0,0 +1,158 @@
+package logstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	LevelInfo  = "info"
+	LevelError = "error"
+)
+
+// Line is a single structured log record as published on BUILDS.logs.<id>
+type Line struct {
+	DeploymentID string    `json:"deployment_id"`
+	Seq          int64     `json:"seq"`
+	Timestamp    time.Time `json:"timestamp"`
+	Step         string    `json:"step"`
+	Level        string    `json:"level"`
+	Message      string    `json:"message"`
+}
+
+// Logger streams build output line by line to BUILDS.logs.<deploymentID>
+type Logger struct {
+	nc           *nats.Conn
+	deploymentID string
+	subject      string
+
+	mu      sync.Mutex
+	seq     int64
+	step    string
+	secrets []string
+}
+
+func New(nc *nats.Conn, deploymentID string) *Logger {
+	return &Logger{
+		nc:           nc,
+		deploymentID: deploymentID,
+		subject:      "BUILDS.logs." + deploymentID,
+	}
+}
+
+// NewDiscard returns a Logger that drops the output, for work that does not
+// belong to a deployment
+func NewDiscard() *Logger {
+	return &Logger{}
+}
+
+// SetAttempt numbers the lines of a retried build after those of the earlier
+// attempts, which were already stored under their sequence numbers
+func (l *Logger) SetAttempt(attempt int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if attempt > 1 {
+		l.seq = int64(attempt-1) << 32
+	}
+}
+
+// Step marks the beginning of a build phase; following lines are tagged with it
+func (l *Logger) Step(name, message string) {
+	l.mu.Lock()
+	l.step = name
+	l.mu.Unlock()
+
+	l.Printf("==> %s", message)
+}
+
+// Redact masks every following occurrence of the given values, e.g. tokens
+// echoed back by git
+func (l *Logger) Redact(secrets ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, secret := range secrets {
+		if secret != "" {
+			l.secrets = append(l.secrets, secret)
+		}
+	}
+}
+
+func (l *Logger) Printf(format string, args ...interface{}) {
+	l.line(LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.line(LevelError, fmt.Sprintf(format, args...))
+}
+
+// Writer returns a line-buffered writer for command stdout/stderr. Close
+// flushes a trailing line without newline.
+func (l *Logger) Writer() *LineWriter {
+	return &LineWriter{logger: l}
+}
+
+func (l *Logger) line(level, text string) {
+	l.mu.Lock()
+	for _, secret := range l.secrets {
+		text = strings.ReplaceAll(text, secret, "********")
+	}
+	l.seq++
+	entry := Line{
+		DeploymentID: l.deploymentID,
+		Seq:          l.seq,
+		Timestamp:    time.Now().UTC(),
+		Step:         l.step,
+		Level:        level,
+		Message:      text,
+	}
+	l.mu.Unlock()
+
+	if l.nc == nil {
+		return
+	}
+
+	// Core publish is enough: the BUILDS stream still captures it
+	data, _ := json.Marshal(entry)
+	l.nc.Publish(l.subject, data)
+}
+
+type LineWriter struct {
+	logger *Logger
+	mu     sync.Mutex
+	buf    []byte
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		// Progress output rewrites the current line with \r
+		i := strings.IndexAny(string(w.buf), "\r\n")
+		if i < 0 {
+			break
+		}
+		if text := strings.TrimRight(string(w.buf[:i]), " "); text != "" {
+			w.logger.line(LevelInfo, text)
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *LineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.logger.line(LevelInfo, string(w.buf))
+		w.buf = nil
+	}
+	return nil
+}
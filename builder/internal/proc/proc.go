@@ -0,0 +1,24 @@
+package proc
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Grace period for processes that keep the output pipes open after a kill
+const waitDelay = 10 * time.Second
+
+// Command is exec.CommandContext for build steps. The command runs in its own
+// process group and cancelling ctx kills the whole group, so package managers,
+// compilers and docker clients started by a shell do not outlive the build.
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
@@ -1,12 +1,25 @@
 package runner
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dejavu/builder/internal/proc"
 )
 
+// BuildOptions carries the per-build settings shared by every runner
+type BuildOptions struct {
+	InstallCommand string // replaces the framework's install step
+	BuildCommand   string
+	Env            []string
+	Output         io.Writer
+}
+
 type Runner interface {
-	Build(projectPath string, buildCommand string) error
+	Build(ctx context.Context, projectPath string, opts BuildOptions) error
 }
 
 func GetRunner(framework string) Runner {
@@ -15,6 +28,8 @@ func GetRunner(framework string) Runner {
 		return &NextJSRunner{}
 	case "nuxtjs":
 		return &NuxtRunner{}
+	case "remix", "sveltekit", "astro", "vite":
+		return &BundlerRunner{}
 	case "nodejs":
 		return &NodeRunner{}
 	case "bun":
@@ -23,6 +38,8 @@ func GetRunner(framework string) Runner {
 		return &GoRunner{}
 	case "php":
 		return &PHPRunner{}
+	case "django", "flask", "rails", "rust":
+		return &ImageRunner{}
 	case "static":
 		return &StaticRunner{}
 	default:
@@ -33,45 +50,61 @@ func GetRunner(framework string) Runner {
 // Next.js Runner
 type NextJSRunner struct{}
 
-func (r *NextJSRunner) Build(projectPath string, buildCommand string) error {
-	if buildCommand == "" {
-		buildCommand = "npm run build"
+func (r *NextJSRunner) Build(ctx context.Context, projectPath string, opts BuildOptions) error {
+	if opts.BuildCommand == "" {
+		opts.BuildCommand = "npm run build"
 	}
 
 	// Install dependencies
-	if err := runCommand(projectPath, "npm", "install"); err != nil {
+	if err := install(ctx, projectPath, opts, "npm", "install"); err != nil {
 		return err
 	}
 
 	// Build
-	return runCommand(projectPath, "sh", "-c", buildCommand)
+	return runCommand(ctx, projectPath, opts, "sh", "-c", opts.BuildCommand)
 }
 
 // Nuxt Runner
 type NuxtRunner struct{}
 
-func (r *NuxtRunner) Build(projectPath string, buildCommand string) error {
-	if buildCommand == "" {
-		buildCommand = "npm run build"
+func (r *NuxtRunner) Build(ctx context.Context, projectPath string, opts BuildOptions) error {
+	if opts.BuildCommand == "" {
+		opts.BuildCommand = "npm run build"
 	}
 
-	if err := runCommand(projectPath, "npm", "install"); err != nil {
+	if err := install(ctx, projectPath, opts, "npm", "install"); err != nil {
 		return err
 	}
 
-	return runCommand(projectPath, "sh", "-c", buildCommand)
+	return runCommand(ctx, projectPath, opts, "sh", "-c", opts.BuildCommand)
+}
+
+// Remix, SvelteKit, Astro and Vite Runner
+type BundlerRunner struct{}
+
+func (r *BundlerRunner) Build(ctx context.Context, projectPath string, opts BuildOptions) error {
+	if opts.BuildCommand == "" {
+		opts.BuildCommand = "npm run build"
+	}
+
+	if err := install(ctx, projectPath, opts, "npm", "install"); err != nil {
+		return err
+	}
+
+	return runCommand(ctx, projectPath, opts, "sh", "-c", opts.BuildCommand)
 }
 
 // Node.js Runner
 type NodeRunner struct{}
 
-func (r *NodeRunner) Build(projectPath string, buildCommand string) error {
-	if err := runCommand(projectPath, "npm", "install"); err != nil {
+func (r *NodeRunner) Build(ctx context.Context, projectPath string, opts BuildOptions) error {
+	if err := install(ctx, projectPath, opts, "npm", "install"); err != nil {
 		return err
 	}
 
-	if buildCommand != "" && buildCommand != "npm run build" {
-		return runCommand(projectPath, "sh", "-c", buildCommand)
+	// Plain Node apps only build when package.json has a build script
+	if opts.BuildCommand != "" {
+		return runCommand(ctx, projectPath, opts, "sh", "-c", opts.BuildCommand)
 	}
 
 	return nil
@@ -80,13 +113,13 @@ func (r *NodeRunner) Build(projectPath string, buildCommand string) error {
 // Bun Runner
 type BunRunner struct{}
 
-func (r *BunRunner) Build(projectPath string, buildCommand string) error {
-	if err := runCommand(projectPath, "bun", "install"); err != nil {
+func (r *BunRunner) Build(ctx context.Context, projectPath string, opts BuildOptions) error {
+	if err := install(ctx, projectPath, opts, "bun", "install"); err != nil {
 		return err
 	}
 
-	if buildCommand != "" {
-		return runCommand(projectPath, "sh", "-c", buildCommand)
+	if opts.BuildCommand != "" {
+		return runCommand(ctx, projectPath, opts, "sh", "-c", opts.BuildCommand)
 	}
 
 	return nil
@@ -95,25 +128,30 @@ func (r *BunRunner) Build(projectPath string, buildCommand string) error {
 // Go Runner
 type GoRunner struct{}
 
-func (r *GoRunner) Build(projectPath string, buildCommand string) error {
-	if buildCommand == "" {
-		buildCommand = "go build -o main ."
+func (r *GoRunner) Build(ctx context.Context, projectPath string, opts BuildOptions) error {
+	if err := install(ctx, projectPath, opts, ""); err != nil {
+		return err
 	}
 
-	return runCommand(projectPath, "sh", "-c", buildCommand)
+	if opts.BuildCommand == "" {
+		opts.BuildCommand = "go build -o main ."
+	}
+	// Static binaries run on any base image; the project's env may say otherwise
+	opts.Env = append([]string{"CGO_ENABLED=0"}, opts.Env...)
+
+	return runCommand(ctx, projectPath, opts, "sh", "-c", opts.BuildCommand)
 }
 
 // PHP Runner
 type PHPRunner struct{}
 
-func (r *PHPRunner) Build(projectPath string, buildCommand string) error {
-	// Check if composer.json exists
-	if err := runCommand(projectPath, "composer", "install", "--no-dev", "--optimize-autoloader"); err != nil {
+func (r *PHPRunner) Build(ctx context.Context, projectPath string, opts BuildOptions) error {
+	if err := install(ctx, projectPath, opts, "composer", "install", "--no-dev", "--optimize-autoloader"); err != nil {
 		return err
 	}
 
-	if buildCommand != "" {
-		return runCommand(projectPath, "sh", "-c", buildCommand)
+	if opts.BuildCommand != "" {
+		return runCommand(ctx, projectPath, opts, "sh", "-c", opts.BuildCommand)
 	}
 
 	return nil
@@ -122,20 +160,46 @@ func (r *PHPRunner) Build(projectPath string, buildCommand string) error {
 // Static Site Runner
 type StaticRunner struct{}
 
-func (r *StaticRunner) Build(projectPath string, buildCommand string) error {
-	if buildCommand != "" {
-		return runCommand(projectPath, "sh", "-c", buildCommand)
+func (r *StaticRunner) Build(ctx context.Context, projectPath string, opts BuildOptions) error {
+	if err := install(ctx, projectPath, opts, ""); err != nil {
+		return err
+	}
+
+	if opts.BuildCommand != "" {
+		return runCommand(ctx, projectPath, opts, "sh", "-c", opts.BuildCommand)
 	}
 	return nil
 }
 
+// Python, Ruby and Rust Runner. Their toolchains only exist in the generated
+// image, which runs the install and build commands itself.
+type ImageRunner struct{}
+
+func (r *ImageRunner) Build(ctx context.Context, projectPath string, opts BuildOptions) error {
+	return nil
+}
+
+// install runs the project's install command, or else the framework default
+// (none when command is empty)
+func install(ctx context.Context, projectPath string, opts BuildOptions, command string, args ...string) error {
+	if opts.InstallCommand != "" {
+		return runCommand(ctx, projectPath, opts, "sh", "-c", opts.InstallCommand)
+	}
+	if command == "" {
+		return nil
+	}
+	return runCommand(ctx, projectPath, opts, command, args...)
+}
+
 // Helper function
-func runCommand(dir string, command string, args ...string) error {
-	cmd := exec.Command(command, args...)
+func runCommand(ctx context.Context, dir string, opts BuildOptions, command string, args ...string) error {
+	cmd := proc.Command(ctx, command, args...)
 	cmd.Dir = dir
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("%v: %s", err, output)
+	cmd.Env = append(os.Environ(), opts.Env...)
+	cmd.Stdout = opts.Output
+	cmd.Stderr = opts.Output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %v", command, strings.Join(args, " "), err)
 	}
 	return nil
 }
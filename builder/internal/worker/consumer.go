@@ -0,0 +1,124 @@
+package worker
+
+// This file is kept identical in builder/internal/worker and
+// deployer/internal/worker; change both copies together. The constants it
+// uses that differ between the two, ackWait, maxDeliver and retryDelay,
+// live in worker.go.
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	heartbeatInterval = 20 * time.Second
+	fetchWait         = 5 * time.Second
+)
+
+// errRetry marks failures worth another attempt, e.g. an unreachable
+// registry or cluster
+type errRetry struct{ err error }
+
+func (e errRetry) Error() string { return e.err.Error() }
+func (e errRetry) Unwrap() error { return e.err }
+
+func retry(err error) error {
+	return errRetry{err}
+}
+
+// consume runs handler for the messages of a durable pull consumer that all
+// workers share, so each message is handled by one of them, with at most
+// concurrency messages in flight on this worker. Messages not acknowledged
+// within ackWait are redelivered; heartbeats keep long handlers from
+// hitting it.
+//
+// The handler's error decides the message's fate: nil acknowledges it, a
+// retry error redelivers it after retryDelay, anything else terminates it.
+// JetStream gives up after maxDeliver deliveries, so handlers check attempt
+// and fail for good on the last one.
+func consume(js nats.JetStreamContext, subject, durable string, concurrency int, ackWait time.Duration, handler func(*nats.Msg) error) error {
+	sub, err := js.PullSubscribe(subject, durable,
+		nats.AckWait(ackWait),
+		nats.MaxDeliver(maxDeliver),
+		nats.DeliverNew(),
+	)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			for {
+				msgs, err := sub.Fetch(1, nats.MaxWait(fetchWait))
+				switch {
+				case errors.Is(err, nats.ErrTimeout) || errors.Is(err, context.DeadlineExceeded):
+					continue
+				case errors.Is(err, nats.ErrConnectionClosed) || errors.Is(err, nats.ErrBadSubscription):
+					return
+				case err != nil:
+					log.Printf("Error fetching %s: %v", subject, err)
+					time.Sleep(fetchWait)
+					continue
+				}
+
+				for _, msg := range msgs {
+					settle(msg, handle(msg, handler))
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// handle runs handler while telling JetStream the message is in progress
+func handle(msg *nats.Msg, handler func(*nats.Msg) error) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := msg.InProgress(); err != nil {
+					log.Printf("Error extending ack deadline: %v", err)
+				}
+			}
+		}
+	}()
+
+	return handler(msg)
+}
+
+func settle(msg *nats.Msg, err error) {
+	var retryErr errRetry
+	switch {
+	case err == nil:
+		err = msg.Ack()
+	case errors.As(err, &retryErr):
+		log.Printf("Retrying %s in %s: %v", msg.Subject, retryDelay, err)
+		err = msg.NakWithDelay(retryDelay)
+	default:
+		log.Printf("Dropping %s: %v", msg.Subject, err)
+		err = msg.Term()
+	}
+	if err != nil {
+		log.Printf("Error acknowledging %s: %v", msg.Subject, err)
+	}
+}
+
+// attempt returns how often the message has been delivered, 1 the first time
+func attempt(msg *nats.Msg) int {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return 1
+	}
+	return int(meta.NumDelivered)
+}
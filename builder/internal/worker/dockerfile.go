@@ -0,0 +1,204 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dejavu/builder/internal/logstream"
+)
+
+// DockerSettings are the project's settings for building from its own
+// Dockerfile
+type DockerSettings struct {
+	Dockerfile string            `json:"dockerfile,omitempty"` // relative to the root directory
+	BuildArgs  map[string]string `json:"build_args,omitempty"`
+	Target     string            `json:"target,omitempty"` // stage of a multi-stage build
+}
+
+// findDockerfile returns the Dockerfile the project is built from: the
+// configured one, which must exist, or a Dockerfile at the project's root. It
+// returns "" when the image is generated.
+func findDockerfile(projectPath, configured string) (string, error) {
+	if configured == "" {
+		if _, err := os.Lstat(filepath.Join(projectPath, "Dockerfile")); err != nil {
+			return "", nil
+		}
+		path, err := resolveInside(projectPath, "Dockerfile")
+		if err != nil {
+			return "", fmt.Errorf("dockerfile: %w", err)
+		}
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+		return "", nil
+	}
+
+	path, err := resolveInside(projectPath, configured)
+	if err == errOutsideRepository {
+		return "", fmt.Errorf("dockerfile %s is outside the project", configured)
+	}
+	if err != nil {
+		return "", fmt.Errorf("dockerfile %s not found in the repository", configured)
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", fmt.Errorf("dockerfile %s not found in the repository", configured)
+	}
+	return path, nil
+}
+
+// buildFromDockerfile builds the image with the project's own Dockerfile and
+// the project directory as context, which keeps its .dockerignore in effect.
+// It returns the port the image exposes, 0 when it exposes none; errors are
+// already logged.
+func (w *Worker) buildFromDockerfile(ctx context.Context, logger *logstream.Logger, event DeploymentEvent, projectPath, dockerfile, imageTag string) (int, error) {
+	name, _ := filepath.Rel(projectPath, dockerfile)
+	logger.Step("image", fmt.Sprintf("Building Docker image from %s...", filepath.ToSlash(name)))
+
+	args := []string{"build", "--progress=plain", "-f", dockerfile, "-t", imageTag}
+	if event.Docker.Target != "" {
+		logger.Printf("Target stage: %s", event.Docker.Target)
+		args = append(args, "--target", event.Docker.Target)
+	}
+	for _, arg := range dockerBuildArgs(event) {
+		args = append(args, "--build-arg", arg)
+	}
+	secrets, env := dockerSecrets(event)
+	for _, secret := range secrets {
+		args = append(args, "--secret", secret)
+	}
+	for key := range event.BuildEnv {
+		if dockerCLIVar(key) {
+			logger.Printf("Build-time env var %s is not passed to the build, as it would configure the docker CLI", key)
+		}
+	}
+	if event.NoCache {
+		logger.Printf("Building without cache")
+		args = append(args, "--no-cache")
+	}
+
+	// The build-time env vars reach the docker CLI only through its
+	// environment, never its command line
+	env = append(env, "DOCKER_BUILDKIT=1")
+	if err := runStreamed(ctx, logger, projectPath, env, "docker", append(args, ".")...); err != nil {
+		logger.Errorf("Docker build failed: %v", err)
+		return 0, err
+	}
+
+	port := exposedPort(dockerfile, event.Docker.Target)
+	if port > 0 {
+		logger.Printf("Image exposes port %d", port)
+	}
+	return port, nil
+}
+
+// dockerBuildArgs passes the project's build args. Docker only hands them to
+// ARGs the Dockerfile declares, and they end up in the image's history, so
+// they are for settings, not secrets.
+func dockerBuildArgs(event DeploymentEvent) []string {
+	args := make([]string, 0, len(event.Docker.BuildArgs))
+	for k, v := range event.Docker.BuildArgs {
+		args = append(args, k+"="+v)
+	}
+	sort.Strings(args)
+	return args
+}
+
+// dockerSecrets exposes the build-time env vars as BuildKit secrets, read from
+// the docker CLI's environment, and returns that environment. A RUN
+// instruction mounts them with --mount=type=secret,id=KEY, which keeps them
+// out of the image and its history.
+func dockerSecrets(event DeploymentEvent) ([]string, []string) {
+	secrets := make([]string, 0, len(event.BuildEnv))
+	env := make([]string, 0, len(event.BuildEnv))
+	for k, v := range event.BuildEnv {
+		if dockerCLIVar(k) {
+			continue
+		}
+		secrets = append(secrets, fmt.Sprintf("id=%s,env=%s", k, k))
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(secrets)
+	return secrets, env
+}
+
+// dockerCLIVar reports whether a variable configures the docker CLI itself,
+// e.g. DOCKER_HOST, so a project must not set it there
+func dockerCLIVar(key string) bool {
+	key = strings.ToUpper(key)
+	for _, prefix := range []string{"DOCKER_", "BUILDKIT_", "BUILDX_"} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return key == "PATH" || key == "HOME"
+}
+
+// exposedPort returns the first port the target stage, or the last stage
+// without a target, EXPOSEs, including those of the stages it builds on
+func exposedPort(dockerfile, target string) int {
+	file, err := os.Open(dockerfile)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	stages := map[string][]int{} // ports of the named stages
+	var current []int
+	var name string
+
+	save := func() {
+		if name != "" {
+			stages[name] = current
+		}
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "FROM":
+			save()
+			var image string
+			name = ""
+			for i := 1; i < len(fields); i++ {
+				switch {
+				case strings.HasPrefix(fields[i], "--"):
+				case strings.EqualFold(fields[i], "AS") && i+1 < len(fields):
+					name = strings.ToLower(fields[i+1])
+					i++
+				case image == "":
+					image = strings.ToLower(fields[i])
+				}
+			}
+			// A stage built on an earlier one inherits its ports
+			current = append([]int(nil), stages[image]...)
+		case "EXPOSE":
+			for _, field := range fields[1:] {
+				port, err := strconv.Atoi(strings.SplitN(field, "/", 2)[0])
+				if err == nil && port > 0 && port <= 65535 {
+					current = append(current, port)
+				}
+			}
+		}
+	}
+	save()
+
+	ports := current
+	if target != "" {
+		ports = stages[strings.ToLower(target)]
+	}
+	if len(ports) == 0 {
+		return 0
+	}
+	return ports[0]
+}
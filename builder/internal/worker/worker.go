@@ -1,15 +1,26 @@
 package worker
 
 import (
+	"context"
+	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/dejavu/builder/internal/cache"
 	"github.com/dejavu/builder/internal/detector"
+	"github.com/dejavu/builder/internal/image"
+	"github.com/dejavu/builder/internal/logstream"
+	"github.com/dejavu/builder/internal/proc"
 	"github.com/dejavu/builder/internal/runner"
+	"github.com/dejavu/builder/internal/secret"
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 )
@@ -19,25 +30,126 @@ type Worker struct {
 	js            nats.JetStreamContext
 	workspaceDir  string
 	cacheDir      string
+	cache         *cache.Cache
 	registryURL   string
 	registryUser  string
 	registryPass  string
+	cipher        *secret.Cipher
+	buildTimeout  time.Duration // when the event has none
+	maxBuilds     int           // concurrent builds on this worker
+	analyses      chan struct{} // slots of concurrent analyses, maxBuilds of them
+
+	mu        sync.Mutex
+	builds    map[string]context.CancelCauseFunc // running builds by deployment
+	cancelled map[string]time.Time               // builds cancelled before they started
 }
 
 type DeploymentEvent struct {
+	DeploymentID   string            `json:"deployment_id"`
+	ProjectID      string            `json:"project_id"`
+	RepoURL        string            `json:"repo_url"`
+	BuildCommand   string            `json:"build_command"`
+	OutputDir      string            `json:"output_dir"`
+	RootDirectory  string            `json:"root_directory,omitempty"` // monorepo subdirectory
+	InstallCommand string            `json:"install_command,omitempty"`
+	Framework      string            `json:"framework,omitempty"` // overrides detection
+	Ref            string            `json:"ref"`
+	RefType        string            `json:"ref_type"` // branch | tag | commit
+	// Values encrypted with ENCRYPTION_KEY, decrypted once a build starts
+	BuildEnv       map[string]string `json:"build_env,omitempty"`
+	Credential     *GitCredential    `json:"credential,omitempty"`
+	NoCache        bool              `json:"no_cache,omitempty"` // build from scratch and drop the project's cache
+	BuildTimeout   int               `json:"build_timeout"`      // seconds, from the project's plan
+	Docker         DockerSettings    `json:"docker"`
+}
+
+// CancelBuildEvent stops a running or queued build
+type CancelBuildEvent struct {
 	DeploymentID string `json:"deployment_id"`
-	ProjectID    string `json:"project_id"`
-	RepoURL      string `json:"repo_url"`
-	BuildCommand string `json:"build_command"`
-	OutputDir    string `json:"output_dir"`
-	CommitHash   string `json:"commit_hash"`
 }
 
+// GitCredential gives access to a private repository. Secret is the private
+// key or token, encrypted with ENCRYPTION_KEY.
+type GitCredential struct {
+	Type       string `json:"type"` // deploy_key | token
+	Username   string `json:"username,omitempty"`
+	Secret     string `json:"secret"`
+	KnownHosts string `json:"known_hosts,omitempty"` // trusted besides knownHosts
+}
+
+// knownHosts pins the host keys of GitHub, GitLab and Bitbucket, so deploy
+// keys are never offered to a host that only claims to be one of them
+//
+//go:embed known_hosts
+var knownHosts string
+
+// AnalyzeRequest asks for the detected build settings of a repository, e.g.
+// to fill in a project before its first deployment
+type AnalyzeRequest struct {
+	RepoURL       string         `json:"repo_url"`
+	Ref           string         `json:"ref"`
+	RefType       string         `json:"ref_type"`
+	RootDirectory string         `json:"root_directory,omitempty"`
+	Credential    *GitCredential `json:"credential,omitempty"`
+}
+
+type AnalyzeReply struct {
+	Result *detector.Result `json:"result,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// Outcomes of a build that did not succeed, besides plain errors
+const (
+	ReasonCancelled = "cancelled"
+	ReasonTimedOut  = "timed_out"
+)
+
+var errCancelled = errors.New("build cancelled")
+
+const (
+	// How long a cancellation is remembered for builds that are still queued
+	cancelledRetention = 24 * time.Hour
+
+	// Cloning for an analysis should never take this long
+	analyzeTimeout = 2 * time.Minute
+)
+
+const (
+	// Unacknowledged build requests are redelivered to another builder after
+	// this long, e.g. when a builder dies mid-build
+	ackWait = time.Minute
+
+	// Attempts per build before JetStream gives up on it. The backend fails
+	// builds that are still building after that many build timeouts.
+	maxDeliver = 3
+	retryDelay = 30 * time.Second
+)
+
 type BuildCompleteEvent struct {
-	DeploymentID string `json:"deployment_id"`
-	ImageURL     string `json:"image_url"`
-	Success      bool   `json:"success"`
-	Logs         string `json:"logs"`
+	DeploymentID  string `json:"deployment_id"`
+	ImageURL      string `json:"image_url"`
+	Success       bool   `json:"success"`
+	Port          int    `json:"port,omitempty"`   // port the image listens on
+	Reason        string `json:"reason,omitempty"` // ReasonCancelled or ReasonTimedOut
+	Error         string `json:"error,omitempty"`
+	CommitHash    string `json:"commit_hash,omitempty"`
+	CommitAuthor  string `json:"commit_author,omitempty"`
+	CommitMessage string `json:"commit_message,omitempty"`
+}
+
+// Commit is the commit a build was made from
+type Commit struct {
+	Hash    string
+	Author  string
+	Message string
+}
+
+// BuildStartedEvent tells the deployer, which records deployment statuses,
+// that a builder picked up a build. It precedes the build's BUILDS.complete.
+type BuildStartedEvent struct {
+	DeploymentID string    `json:"deployment_id"`
+	Attempt      int       `json:"attempt"`
+	Timestamp    time.Time `json:"timestamp"`
 }
 
 func New() (*Worker, error) {
@@ -66,6 +178,36 @@ func New() (*Worker, error) {
 		cacheDir = "/tmp/dejavu-cache"
 	}
 
+	maxProjectMB, err := strconv.Atoi(os.Getenv("CACHE_MAX_PROJECT_MB"))
+	if err != nil || maxProjectMB <= 0 {
+		maxProjectMB = 2048
+	}
+
+	maxTotalMB, err := strconv.Atoi(os.Getenv("CACHE_MAX_SIZE_MB"))
+	if err != nil || maxTotalMB <= 0 {
+		maxTotalMB = 20480
+	}
+
+	buildTimeout, err := strconv.Atoi(os.Getenv("BUILD_TIMEOUT"))
+	if err != nil || buildTimeout <= 0 {
+		buildTimeout = 600
+	}
+
+	maxBuilds, err := strconv.Atoi(os.Getenv("MAX_CONCURRENT_BUILDS"))
+	if err != nil || maxBuilds <= 0 {
+		maxBuilds = 1
+	}
+
+	encryptionKey := os.Getenv("ENCRYPTION_KEY")
+	if encryptionKey == "" {
+		return nil, errors.New("ENCRYPTION_KEY is not set")
+	}
+
+	cipher, err := secret.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create directories
 	os.MkdirAll(workspaceDir, 0755)
 	os.MkdirAll(cacheDir, 0755)
@@ -75,195 +217,753 @@ func New() (*Worker, error) {
 		js:           js,
 		workspaceDir: workspaceDir,
 		cacheDir:     cacheDir,
+		cache:        cache.New(cacheDir, int64(maxProjectMB)<<20, int64(maxTotalMB)<<20),
 		registryURL:  os.Getenv("REGISTRY_URL"),
 		registryUser: os.Getenv("REGISTRY_USERNAME"),
 		registryPass: os.Getenv("REGISTRY_PASSWORD"),
+		cipher:       cipher,
+		buildTimeout: time.Duration(buildTimeout) * time.Second,
+		maxBuilds:    maxBuilds,
+		analyses:     make(chan struct{}, maxBuilds),
+		builds:       map[string]context.CancelCauseFunc{},
+		cancelled:    map[string]time.Time{},
 	}, nil
 }
 
 func (w *Worker) Start() error {
-	_, err := w.js.Subscribe("DEPLOYMENTS.request", func(msg *nats.Msg) {
+	// All builders share one durable consumer, so each build runs once
+	err := consume(w.js, "DEPLOYMENTS.request", "builders", w.maxBuilds, ackWait, func(msg *nats.Msg) error {
 		var event DeploymentEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			return fmt.Errorf("invalid deployment event: %w", err)
+		}
+
+		n := attempt(msg)
+		log.Printf("📦 Processing deployment: %s (attempt %d)", event.DeploymentID, n)
+		return w.processBuild(event, n)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Every builder hears every cancellation, only the one running the
+	// build acts on it
+	_, err = w.js.Subscribe("DEPLOYMENTS.cancel", func(msg *nats.Msg) {
+		var event CancelBuildEvent
 		if err := json.Unmarshal(msg.Data, &event); err != nil {
 			log.Printf("Error parsing event: %v", err)
 			msg.Ack()
 			return
 		}
 
-		log.Printf("📦 Processing deployment: %s", event.DeploymentID)
-		w.processBuild(event)
+		w.cancelBuild(event.DeploymentID)
 		msg.Ack()
+	}, nats.DeliverNew())
+	if err != nil {
+		return err
+	}
+
+	// Analysis is request/reply, outside of the streams; one builder answers.
+	// A builder with all its analysis slots taken turns requests down instead
+	// of cloning without bound.
+	_, err = w.nats.QueueSubscribe("BUILDER.analyze", "builders", func(msg *nats.Msg) {
+		select {
+		case w.analyses <- struct{}{}:
+		default:
+			respondAnalyze(msg, AnalyzeReply{Error: "builder is busy, try again later"})
+			return
+		}
+		go func() {
+			defer func() { <-w.analyses }()
+			w.handleAnalyze(msg)
+		}()
 	})
 
 	return err
 }
 
-func (w *Worker) processBuild(event DeploymentEvent) {
-	logs := ""
+// cancelBuild stops a running build, or makes sure a queued one is skipped
+func (w *Worker) cancelBuild(deploymentID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if cancel, ok := w.builds[deploymentID]; ok {
+		log.Printf("🛑 Cancelling build: %s", deploymentID)
+		cancel(errCancelled)
+		return
+	}
+
+	w.cancelled[deploymentID] = time.Now()
+	for id, at := range w.cancelled {
+		if time.Since(at) > cancelledRetention {
+			delete(w.cancelled, id)
+		}
+	}
+}
+
+// startBuild registers a running build so it can be cancelled. It reports
+// false when the build was cancelled while it was queued.
+func (w *Worker) startBuild(deploymentID string, cancel context.CancelCauseFunc) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.cancelled[deploymentID]; ok {
+		delete(w.cancelled, deploymentID)
+		return false
+	}
+	w.builds[deploymentID] = cancel
+	return true
+}
+
+func (w *Worker) finishBuild(deploymentID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.builds, deploymentID)
+}
+
+func (w *Worker) handleAnalyze(msg *nats.Msg) {
+	var reply AnalyzeReply
+	var req AnalyzeRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		reply.Error = "invalid analyze request"
+	} else if result, err := w.analyze(req); err != nil {
+		reply.Error = err.Error()
+	} else {
+		reply.Result = result
+	}
+	respondAnalyze(msg, reply)
+}
+
+func respondAnalyze(msg *nats.Msg, reply AnalyzeReply) {
+	data, _ := json.Marshal(reply)
+	if err := msg.Respond(data); err != nil {
+		log.Printf("Error replying to analyze request: %v", err)
+	}
+}
+
+// analyze clones the repository and detects its framework without building
+func (w *Worker) analyze(req AnalyzeRequest) (*detector.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), analyzeTimeout)
+	defer cancel()
+
+	logger := logstream.NewDiscard()
+	clonePath := filepath.Join(w.workspaceDir, "analyze-"+uuid.New().String()[:8])
+	defer os.RemoveAll(clonePath)
+
+	if err := w.cloneRepo(ctx, logger, req.RepoURL, clonePath, req.Ref, req.RefType, req.Credential); err != nil {
+		return nil, fmt.Errorf("failed to clone repository: %v", err)
+	}
+
+	projectPath, err := projectDir(clonePath, req.RootDirectory)
+	if err != nil {
+		return nil, err
+	}
+	return detector.Analyze(projectPath), nil
+}
+
+// processBuild builds and pushes the image of a deployment and publishes the
+// outcome on BUILDS.complete. It returns a retry error instead when the
+// failure is worth another attempt.
+func (w *Worker) processBuild(event DeploymentEvent, attempt int) (result error) {
+	logger := logstream.New(w.nats, event.DeploymentID)
+	logger.SetAttempt(attempt)
 	success := false
 	imageURL := ""
+	port := 0
+	var commit Commit
+
+	// Every command of the build is killed on cancellation or timeout
+	timeout := time.Duration(event.BuildTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = w.buildTimeout
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	ctx, stop := context.WithTimeout(ctx, timeout)
+	defer stop()
 
 	defer func() {
+		if result != nil {
+			// The next attempt reports the outcome
+			return
+		}
+
 		// Publish build complete event
 		completeEvent := BuildCompleteEvent{
 			DeploymentID: event.DeploymentID,
 			ImageURL:     imageURL,
 			Success:      success,
-			Logs:         logs,
+			Port:         port,
+
+			CommitHash:    commit.Hash,
+			CommitAuthor:  commit.Author,
+			CommitMessage: commit.Message,
+		}
+		if !success {
+			switch {
+			case errors.Is(context.Cause(ctx), errCancelled):
+				logger.Errorf("Build cancelled")
+				completeEvent.Reason = ReasonCancelled
+				completeEvent.Error = "build cancelled"
+			case errors.Is(ctx.Err(), context.DeadlineExceeded):
+				logger.Errorf("Build exceeded the maximum build duration of %s", timeout)
+				completeEvent.Reason = ReasonTimedOut
+				completeEvent.Error = fmt.Sprintf("build exceeded the maximum build duration of %s", timeout)
+			}
 		}
 
 		data, _ := json.Marshal(completeEvent)
-		w.js.Publish("BUILDS.complete", data)
+		if _, err := w.js.Publish("BUILDS.complete", data); err != nil {
+			result = retry(err)
+		}
 	}()
 
+	if !w.startBuild(event.DeploymentID, cancel) {
+		cancel(errCancelled)
+		return
+	}
+	defer w.finishBuild(event.DeploymentID)
+
+	if err := w.publishStarted(event.DeploymentID, attempt); err != nil {
+		// Without it the deployment could not leave queued
+		if attempt < maxDeliver {
+			return retry(err)
+		}
+		log.Printf("Error publishing build started event: %v", err)
+	}
+
+	buildEnv, err := w.decryptBuildEnv(logger, event.BuildEnv)
+	if err != nil {
+		logger.Errorf("%v", err)
+		return
+	}
+	event.BuildEnv = buildEnv
+
 	// 1. Clone repository
 	buildID := uuid.New().String()[:8]
 	buildPath := filepath.Join(w.workspaceDir, buildID)
 	defer os.RemoveAll(buildPath)
 
-	logs += fmt.Sprintf("Cloning repository: %s\n", event.RepoURL)
-	if err := w.cloneRepo(event.RepoURL, buildPath, event.CommitHash); err != nil {
-		logs += fmt.Sprintf("Error cloning: %v\n", err)
+	logger.Step("clone", fmt.Sprintf("Cloning repository: %s (%s %s)", event.RepoURL, event.RefType, event.Ref))
+	if err := w.cloneRepo(ctx, logger, event.RepoURL, buildPath, event.Ref, event.RefType, event.Credential); err != nil {
+		logger.Errorf("Error cloning: %v", err)
 		return
 	}
 
-	// 2. Detect framework
-	logs += "Detecting framework...\n"
-	framework := detector.Detect(buildPath)
-	logs += fmt.Sprintf("Detected: %s\n", framework)
+	resolved, err := headCommit(ctx, buildPath)
+	if err != nil {
+		logger.Errorf("Error reading commit: %v", err)
+		return
+	}
+	commit = *resolved
+	logger.Printf("Commit %s by %s", commit.Hash, commit.Author)
 
-	// 3. Build project
-	logs += "Building project...\n"
-	buildRunner := runner.GetRunner(framework)
-	if err := buildRunner.Build(buildPath, event.BuildCommand); err != nil {
-		logs += fmt.Sprintf("Build failed: %v\n", err)
+	// Monorepo projects build from their subdirectory
+	projectPath, err := projectDir(buildPath, event.RootDirectory)
+	if err != nil {
+		logger.Errorf("%v", err)
 		return
 	}
-	logs += "Build completed successfully\n"
+	if event.RootDirectory != "" {
+		logger.Printf("Using root directory %s", event.RootDirectory)
+	}
 
-	// 4. Build Docker image
-	logs += "Building Docker image...\n"
 	imageName := fmt.Sprintf("%s/dejavu/%s", w.registryURL, event.ProjectID)
 	imageTag := fmt.Sprintf("%s:%s", imageName, buildID)
 
-	if err := w.buildDockerImage(buildPath, imageTag, framework, event.OutputDir); err != nil {
-		logs += fmt.Sprintf("Docker build failed: %v\n", err)
+	// A Dockerfile in the project takes the place of detection, the runner
+	// and the generated image
+	dockerfile, err := findDockerfile(projectPath, event.Docker.Dockerfile)
+	if err != nil {
+		logger.Errorf("%v", err)
+		return
+	}
+	var imagePort int
+	if dockerfile != "" {
+		imagePort, err = w.buildFromDockerfile(ctx, logger, event, projectPath, dockerfile, imageTag)
+	} else {
+		imagePort, err = w.buildFromSource(ctx, logger, event, buildPath, projectPath, imageTag)
+	}
+	if err != nil {
 		return
 	}
 
 	// 5. Push to registry
-	logs += "Pushing to registry...\n"
-	if err := w.pushImage(imageTag); err != nil {
-		logs += fmt.Sprintf("Push failed: %v\n", err)
+	logger.Step("push", "Pushing to registry...")
+	if err := w.pushImage(ctx, logger, imageTag); err != nil {
+		// Registries fail transiently, unlike the build itself
+		if ctx.Err() == nil && attempt < maxDeliver {
+			logger.Errorf("Push failed, retrying the build: %v", err)
+			return retry(err)
+		}
+		logger.Errorf("Push failed: %v", err)
 		return
 	}
 
-	logs += "✅ Deployment build complete\n"
+	logger.Step("done", "✅ Deployment build complete")
 	success = true
 	imageURL = imageTag
+	port = imagePort
+	return
 }
 
-func (w *Worker) cloneRepo(repoURL, destination, commitHash string) error {
-	args := []string{"clone", "--depth", "1"}
-	if commitHash != "" {
-		args = append(args, "--branch", commitHash)
+// buildFromSource detects the framework, builds the project with its runner
+// and packages the output into a generated image. It returns the port the
+// image listens on; errors are already logged.
+func (w *Worker) buildFromSource(ctx context.Context, logger *logstream.Logger, event DeploymentEvent, buildPath, projectPath, imageTag string) (int, error) {
+	// 2. Detect framework
+	var analysis *detector.Result
+	if event.Framework == "" {
+		logger.Step("detect", "Detecting framework...")
+		analysis = detector.Analyze(projectPath)
+		logger.Printf("Detected: %s (confidence %.0f%%)", analysis.Framework, analysis.Confidence*100)
+	} else {
+		logger.Step("detect", fmt.Sprintf("Using framework %s", event.Framework))
+		analysis = detector.AnalyzeAs(projectPath, event.Framework)
 	}
-	args = append(args, repoURL, destination)
+	applyProjectSettings(analysis, event)
+	if analysis.PackageManager != "" {
+		logger.Printf("Package manager: %s", analysis.PackageManager)
+	}
+	framework := analysis.Framework
 
-	cmd := exec.Command("git", args...)
-	output, err := cmd.CombinedOutput()
+	// 3. Build project
+	logger.Step("build", "Building project...")
+	if event.NoCache {
+		logger.Printf("Building without cache")
+		if err := w.cache.Clear(event.ProjectID); err != nil {
+			logger.Printf("Error clearing cache: %v", err)
+		}
+	}
+	caches, cacheEnv := w.buildCaches(event.ProjectID, buildPath, projectPath, analysis)
+	for _, entry := range caches {
+		hit, err := w.cache.Restore(event.ProjectID, projectPath, entry)
+		switch {
+		case err != nil:
+			logger.Printf("Ignoring unusable cache of %s: %v", entry.Path, err)
+		case hit:
+			logger.Printf("Restored %s from cache", entry.Path)
+		}
+	}
+
+	output := logger.Writer()
+	buildRunner := runner.GetRunner(framework)
+	err := buildRunner.Build(ctx, projectPath, runner.BuildOptions{
+		InstallCommand: analysis.InstallCommand,
+		BuildCommand:   analysis.BuildCommand,
+		Env:            append(buildEnv(event.BuildEnv), cacheEnv...),
+		Output:         output,
+	})
+	output.Close()
 	if err != nil {
-		return fmt.Errorf("%v: %s", err, output)
+		logger.Errorf("Build failed: %v", err)
+		return 0, err
 	}
-	return nil
+	logger.Printf("Build completed successfully")
+
+	for _, entry := range caches {
+		if err := w.cache.Save(event.ProjectID, projectPath, entry); err != nil {
+			logger.Printf("Error caching %s: %v", entry.Path, err)
+		}
+	}
+
+	// 4. Build Docker image
+	logger.Step("image", "Building Docker image...")
+	port, err := w.buildDockerImage(ctx, logger, projectPath, imageTag, analysis, event.NoCache)
+	if err != nil {
+		logger.Errorf("Docker build failed: %v", err)
+		return 0, err
+	}
+
+	return port, nil
 }
 
-func (w *Worker) buildDockerImage(buildPath, imageTag, framework, outputDir string) error {
-	// Create Dockerfile
-	dockerfile := w.generateDockerfile(framework, outputDir)
-	dockerfilePath := filepath.Join(buildPath, "Dockerfile.dejavu")
-	if err := os.WriteFile(dockerfilePath, []byte(dockerfile), 0644); err != nil {
+// Lockfiles that pin a Node project's dependencies
+var nodeLockfiles = []string{"package-lock.json", "pnpm-lock.yaml", "yarn.lock", "bun.lockb", "bun.lock"}
+
+// buildCaches picks what the project keeps between builds: dependency
+// directories keyed by their lockfile, framework build caches and the
+// package managers' own caches, which are passed to the runner as env vars
+func (w *Worker) buildCaches(projectID, clonePath, projectPath string, analysis *detector.Result) ([]cache.Entry, []string) {
+	lockKey := func(names ...string) string {
+		if key := cache.HashFiles(projectPath, names...); key != "" {
+			return key
+		}
+		// Workspaces keep the lockfile at the repository root
+		return cache.HashFiles(clonePath, names...)
+	}
+
+	var entries []cache.Entry
+	tools := map[string]string{} // env var -> tool cache
+	switch analysis.PackageManager {
+	case "npm":
+		tools["npm_config_cache"] = "npm"
+	case "pnpm":
+		tools["npm_config_store_dir"] = "pnpm-store"
+	case "yarn":
+		tools["YARN_CACHE_FOLDER"] = "yarn"
+	case "bun":
+		tools["BUN_INSTALL_CACHE_DIR"] = "bun"
+	case "go":
+		tools["GOMODCACHE"] = "go-mod"
+		tools["GOCACHE"] = "go-build"
+	case "composer":
+		tools["COMPOSER_CACHE_DIR"] = "composer"
+		if key := lockKey("composer.lock"); key != "" {
+			entries = append(entries, cache.Entry{Path: "vendor", Key: key})
+		}
+	}
+
+	switch analysis.PackageManager {
+	case "npm", "pnpm", "yarn", "bun":
+		// npm ci always starts from an empty node_modules
+		key := lockKey(nodeLockfiles...)
+		if key != "" && !strings.HasPrefix(analysis.InstallCommand, "npm ci") {
+			entries = append(entries, cache.Entry{Path: "node_modules", Key: key})
+		}
+	}
+
+	if analysis.Framework == "nextjs" {
+		entries = append(entries, cache.Entry{Path: ".next/cache"})
+	}
+
+	var env []string
+	for name, tool := range tools {
+		dir, err := w.cache.ToolDir(projectID, tool)
+		if err != nil {
+			log.Printf("Error creating %s cache: %v", tool, err)
+			continue
+		}
+		env = append(env, name+"="+dir)
+	}
+	return entries, env
+}
+
+// projectDir returns the directory to build, which must exist in the clone
+func projectDir(clonePath, rootDirectory string) (string, error) {
+	projectPath, err := resolveInside(clonePath, rootDirectory)
+	if err != nil {
+		return "", fmt.Errorf("root directory %s: %w", rootDirectory, err)
+	}
+	if info, err := os.Stat(projectPath); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("root directory %s does not exist in the repository", rootDirectory)
+	}
+	return projectPath, nil
+}
+
+var errOutsideRepository = errors.New("points outside the repository")
+
+// resolveInside resolves the symlinks of name, a slash-separated path
+// relative to dir, and checks that the result is still inside dir. A
+// repository could otherwise commit a link to any directory of the builder.
+func resolveInside(dir, name string) (string, error) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return "", errors.New("does not exist in the repository")
+	}
+	if err != nil {
+		return "", err
+	}
+	if path != root && !strings.HasPrefix(path, root+string(os.PathSeparator)) {
+		return "", errOutsideRepository
+	}
+	return path, nil
+}
+
+// applyProjectSettings overrides the detected commands with the ones set on
+// the project; empty ones are unset
+func applyProjectSettings(analysis *detector.Result, event DeploymentEvent) {
+	if event.InstallCommand != "" {
+		analysis.InstallCommand = event.InstallCommand
+	}
+	if event.BuildCommand != "" {
+		analysis.BuildCommand = event.BuildCommand
+	}
+	if event.OutputDir != "" {
+		analysis.OutputDir = event.OutputDir
+	}
+}
+
+// publishStarted announces on BUILDS.started that the build is running
+func (w *Worker) publishStarted(deploymentID string, attempt int) error {
+	data, _ := json.Marshal(BuildStartedEvent{
+		DeploymentID: deploymentID,
+		Attempt:      attempt,
+		Timestamp:    time.Now(),
+	})
+	_, err := w.js.Publish("BUILDS.started", data)
+	return err
+}
+
+// cloneRepo checks out a single ref with a shallow fetch. Commits are fetched
+// by SHA, which GitHub, GitLab and Gitea allow; other servers fall back to a
+// full fetch.
+func (w *Worker) cloneRepo(ctx context.Context, logger *logstream.Logger, repoURL, destination, ref, refType string, credential *GitCredential) error {
+	auth, err := w.gitAuth(logger, credential)
+	if err != nil {
 		return err
 	}
+	defer auth.cleanup()
 
-	// Build image
-	cmd := exec.Command("docker", "build", "-f", dockerfilePath, "-t", imageTag, ".")
-	cmd.Dir = buildPath
-	output, err := cmd.CombinedOutput()
+	if auth.ssh {
+		repoURL = sshURL(repoURL)
+	}
+
+	git := func(dir string, args ...string) error {
+		return runGit(ctx, logger, dir, auth.env, args...)
+	}
+
+	if err := git("", "init", "--quiet", destination); err != nil {
+		return err
+	}
+	if err := git(destination, "remote", "add", "origin", repoURL); err != nil {
+		return err
+	}
+
+	var refspec string
+	switch refType {
+	case "tag":
+		refspec = "refs/tags/" + ref
+	case "commit":
+		refspec = ref
+	default:
+		refspec = "refs/heads/" + ref
+	}
+	if ref == "" {
+		refspec = "HEAD"
+	}
+
+	err = git(destination, "fetch", "--progress", "--depth", "1", "origin", refspec)
+	if err != nil && refType == "commit" {
+		logger.Printf("Shallow fetch of %s failed, fetching full history", ref)
+		if err := git(destination, "fetch", "--progress", "origin"); err != nil {
+			return err
+		}
+		return git(destination, "checkout", "--quiet", "--detach", ref)
+	}
 	if err != nil {
-		return fmt.Errorf("%v: %s", err, output)
+		return err
 	}
 
-	return nil
+	return git(destination, "checkout", "--quiet", "--detach", "FETCH_HEAD")
 }
 
-func (w *Worker) pushImage(imageTag string) error {
-	// Login to registry
-	if w.registryUser != "" && w.registryPass != "" {
-		loginCmd := exec.Command("docker", "login", w.registryURL, "-u", w.registryUser, "-p", w.registryPass)
-		if output, err := loginCmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("registry login failed: %v: %s", err, output)
+// Shorter values are not masked in build logs: they are rarely secret, e.g.
+// true or 3000, and masking them would garble the output
+const minRedactLength = 6
+
+// decryptBuildEnv decrypts the build-time env vars, which travel encrypted
+// like the credential, and masks their values in the build logs
+func (w *Worker) decryptBuildEnv(logger *logstream.Logger, sealed map[string]string) (map[string]string, error) {
+	env := make(map[string]string, len(sealed))
+	for key, value := range sealed {
+		plaintext, err := w.cipher.Decrypt(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt build-time env var %s: %w", key, err)
+		}
+		if len(plaintext) >= minRedactLength {
+			logger.Redact(plaintext)
 		}
+		env[key] = plaintext
 	}
+	return env, nil
+}
 
-	// Push image
-	cmd := exec.Command("docker", "push", imageTag)
-	output, err := cmd.CombinedOutput()
+// gitAuth holds the environment that lets git authenticate for one clone
+type gitAuth struct {
+	env     []string
+	ssh     bool
+	cleanup func()
+}
+
+// gitAuth writes the decrypted credential to a private temporary directory:
+// a deploy key for GIT_SSH_COMMAND, or an askpass helper that reads the token
+// from the environment. Neither ever appears in arguments or logs.
+func (w *Worker) gitAuth(logger *logstream.Logger, credential *GitCredential) (*gitAuth, error) {
+	auth := &gitAuth{
+		// Never wait for a password prompt
+		env:     []string{"GIT_TERMINAL_PROMPT=0"},
+		cleanup: func() {},
+	}
+	if credential == nil {
+		return auth, nil
+	}
+
+	plaintext, err := w.cipher.Decrypt(credential.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt repository credential: %w", err)
+	}
+	logger.Redact(plaintext)
+
+	dir, err := os.MkdirTemp("", "dejavu-git-")
+	if err != nil {
+		return nil, err
+	}
+	auth.cleanup = func() { os.RemoveAll(dir) }
+
+	switch credential.Type {
+	case "deploy_key":
+		keyPath := filepath.Join(dir, "id_ed25519")
+		if err := os.WriteFile(keyPath, []byte(plaintext), 0600); err != nil {
+			auth.cleanup()
+			return nil, err
+		}
+		// Hosts with no pinned key are refused rather than trusted on first use
+		knownHostsPath := filepath.Join(dir, "known_hosts")
+		if err := os.WriteFile(knownHostsPath, []byte(knownHosts+"\n"+credential.KnownHosts+"\n"), 0600); err != nil {
+			auth.cleanup()
+			return nil, err
+		}
+		auth.ssh = true
+		auth.env = append(auth.env, fmt.Sprintf(
+			"GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o UserKnownHostsFile=%s -o GlobalKnownHostsFile=/dev/null -o StrictHostKeyChecking=yes",
+			keyPath, knownHostsPath,
+		))
+		logger.Printf("Using deploy key")
+	case "token":
+		askpass := filepath.Join(dir, "askpass.sh")
+		script := "#!/bin/sh\ncase \"$1\" in\n  Username*) echo \"$DEJAVU_GIT_USERNAME\" ;;\n  *) echo \"$DEJAVU_GIT_PASSWORD\" ;;\nesac\n"
+		if err := os.WriteFile(askpass, []byte(script), 0700); err != nil {
+			auth.cleanup()
+			return nil, err
+		}
+		auth.env = append(auth.env,
+			"GIT_ASKPASS="+askpass,
+			"DEJAVU_GIT_USERNAME="+credential.Username,
+			"DEJAVU_GIT_PASSWORD="+plaintext,
+		)
+		logger.Printf("Using access token for %s", credential.Username)
+	default:
+		auth.cleanup()
+		return nil, fmt.Errorf("unknown credential type %q", credential.Type)
+	}
+	return auth, nil
+}
+
+// sshURL turns an HTTPS clone URL into the SSH form deploy keys need. The
+// ssh:// form keeps a port the URL names, which scp-style URLs cannot.
+func sshURL(repoURL string) string {
+	for _, scheme := range []string{"https://", "http://"} {
+		if !strings.HasPrefix(repoURL, scheme) {
+			continue
+		}
+		rest := strings.TrimPrefix(repoURL, scheme)
+		if i := strings.Index(rest, "@"); i >= 0 && i < strings.Index(rest, "/") {
+			rest = rest[i+1:]
+		}
+		parts := strings.SplitN(rest, "/", 2)
+		if len(parts) != 2 {
+			return repoURL
+		}
+		path := strings.TrimSuffix(parts[1], "/")
+		if !strings.HasSuffix(path, ".git") {
+			path += ".git"
+		}
+		return fmt.Sprintf("ssh://git@%s/%s", parts[0], path)
+	}
+	return repoURL
+}
+
+// runGit runs git with extra environment, streaming its output into the
+// build log
+func runGit(ctx context.Context, logger *logstream.Logger, dir string, env []string, args ...string) error {
+	output := logger.Writer()
+	defer output.Close()
+
+	cmd := proc.Command(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	return cmd.Run()
+}
+
+// headCommit reads the checked out commit
+func headCommit(ctx context.Context, repoPath string) (*Commit, error) {
+	cmd := proc.Command(ctx, "git", "log", "-1", "--format=%H%x00%an <%ae>%x00%B")
+	cmd.Dir = repoPath
+	output, err := cmd.Output()
 	if err != nil {
-		return fmt.Errorf("%v: %s", err, output)
+		return nil, err
 	}
 
-	return nil
+	parts := strings.SplitN(string(output), "\x00", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("unexpected git log output")
+	}
+	return &Commit{
+		Hash:    parts[0],
+		Author:  parts[1],
+		Message: strings.TrimSpace(parts[2]),
+	}, nil
 }
 
-func (w *Worker) generateDockerfile(framework, outputDir string) string {
-	switch framework {
-	case "nextjs":
-		return `FROM node:18-alpine
-WORKDIR /app
-COPY . .
-RUN npm install
-RUN npm run build
-EXPOSE 3000
-CMD ["npm", "start"]`
+// buildDockerImage generates the project's Dockerfile and builds it. It
+// returns the port the image listens on.
+func (w *Worker) buildDockerImage(ctx context.Context, logger *logstream.Logger, buildPath, imageTag string, analysis *detector.Result, noCache bool) (int, error) {
+	// Create Dockerfile
+	generated, err := image.Generate(buildPath, analysis)
+	if err != nil {
+		return 0, err
+	}
+	dockerfilePath := filepath.Join(buildPath, "Dockerfile.dejavu")
+	if err := os.WriteFile(dockerfilePath, []byte(generated.Dockerfile), 0644); err != nil {
+		return 0, err
+	}
+	// The context's own .dockerignore is written for the project's use and
+	// could drop the build output
+	if err := os.WriteFile(dockerfilePath+".dockerignore", []byte(generated.Dockerignore), 0644); err != nil {
+		return 0, err
+	}
 
-	case "nodejs":
-		return fmt.Sprintf(`FROM node:18-alpine
-WORKDIR /app
-COPY . .
-RUN npm install
-EXPOSE 3000
-CMD ["node", "index.js"]`)
+	// Build image
+	args := []string{"build", "--progress=plain", "-f", dockerfilePath, "-t", imageTag}
+	if noCache {
+		args = append(args, "--no-cache")
+	}
+	return generated.Port, runStreamed(ctx, logger, buildPath, nil, "docker", append(args, ".")...)
+}
 
-	case "static":
-		return fmt.Sprintf(`FROM nginx:alpine
-COPY %s /usr/share/nginx/html
-EXPOSE 80
-CMD ["nginx", "-g", "daemon off;"]`, outputDir)
+func (w *Worker) pushImage(ctx context.Context, logger *logstream.Logger, imageTag string) error {
+	// Login to registry
+	if w.registryUser != "" && w.registryPass != "" {
+		loginCmd := proc.Command(ctx, "docker", "login", w.registryURL, "-u", w.registryUser, "--password-stdin")
+		loginCmd.Stdin = strings.NewReader(w.registryPass)
+		if output, err := loginCmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("registry login failed: %v: %s", err, output)
+		}
+	}
 
-	case "go":
-		return `FROM golang:1.21-alpine AS builder
-WORKDIR /app
-COPY . .
-RUN go build -o main .
-
-FROM alpine:latest
-WORKDIR /app
-COPY --from=builder /app/main .
-EXPOSE 8080
-CMD ["./main"]`
-
-	case "php":
-		return `FROM php:8.2-apache
-COPY . /var/www/html/
-EXPOSE 80`
+	// Push image
+	return runStreamed(ctx, logger, "", nil, "docker", "push", imageTag)
+}
 
-	default:
-		return fmt.Sprintf(`FROM nginx:alpine
-COPY %s /usr/share/nginx/html
-EXPOSE 80
-CMD ["nginx", "-g", "daemon off;"]`, outputDir)
+// runStreamed runs a command and streams its stdout/stderr into the build log
+func runStreamed(ctx context.Context, logger *logstream.Logger, dir string, env []string, command string, args ...string) error {
+	output := logger.Writer()
+	defer output.Close()
+
+	cmd := proc.Command(ctx, command, args...)
+	cmd.Dir = dir
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
 	}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	return cmd.Run()
+}
+
+// buildEnv converts project build-time variables into KEY=VALUE pairs
+func buildEnv(vars map[string]string) []string {
+	env := make([]string, 0, len(vars))
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	return env
 }
 
 func (w *Worker) Close() {
@@ -271,4 +971,3 @@ func (w *Worker) Close() {
 		w.nats.Close()
 	}
 }
-
@@ -0,0 +1,82 @@
+package k8s
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	metav1ac "k8s.io/client-go/applyconfigurations/meta/v1"
+)
+
+// FieldManager owns every field the deployer applies
+const FieldManager = "dejavu-deployer"
+
+// ownerManager owns the owner references the controller adds to objects the
+// deployer applied without one, so later applies keep them
+const ownerManager = "dejavu-controller"
+
+// Managers that legitimately write to objects we apply: Kubernetes'
+// own controllers and the deployer before it switched to server-side apply.
+var trustedManagers = map[string]bool{
+	FieldManager:              true,
+	ownerManager:              true,
+	"deployer":                true,
+	"kube-controller-manager": true,
+	"k3s":                     true,
+}
+
+// Conflicting fields are taken over, which reverts manual edits of the
+// fields the deployer applies. Fields only someone else set stay.
+var applyOptions = metav1.ApplyOptions{FieldManager: FieldManager, Force: true}
+
+// Owner is the object another object is garbage-collected with: the app
+// Deployment for its Service, Ingress and HPA, and in controller mode the
+// DejavuApp for everything of a project
+type Owner struct {
+	APIVersion string
+	Kind       string
+	Name       string
+	UID        types.UID
+}
+
+func ownerOf(d *appsv1.Deployment) *Owner {
+	return &Owner{APIVersion: "apps/v1", Kind: "Deployment", Name: d.Name, UID: d.UID}
+}
+
+func (o *Owner) reference() *metav1ac.OwnerReferenceApplyConfiguration {
+	return metav1ac.OwnerReference().
+		WithAPIVersion(o.APIVersion).
+		WithKind(o.Kind).
+		WithName(o.Name).
+		WithUID(o.UID).
+		WithController(true).
+		WithBlockOwnerDeletion(true)
+}
+
+// reportDrift logs when fields of an object we manage were changed by
+// someone else, e.g. kubectl edit. The following apply takes back the fields
+// the deployer sets; fields it does not set keep the other manager's values.
+func reportDrift(kind string, obj metav1.Object) {
+	managers := map[string]bool{}
+	for _, entry := range obj.GetManagedFields() {
+		// Status and scale updates are expected
+		if entry.Subresource != "" || trustedManagers[entry.Manager] {
+			continue
+		}
+		managers[entry.Manager] = true
+	}
+	if len(managers) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(managers))
+	for name := range managers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	log.Printf("⚠️  Drift: %s %s/%s was modified by %s, reapplying the deployer's fields; fields it does not set are kept", kind, obj.GetNamespace(), obj.GetName(), strings.Join(names, ", "))
+}
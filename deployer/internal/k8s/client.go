@@ -2,8 +2,12 @@ package k8s
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/pem"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
@@ -13,13 +17,24 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
+	autoscalingv2ac "k8s.io/client-go/applyconfigurations/autoscaling/v2"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	metav1ac "k8s.io/client-go/applyconfigurations/meta/v1"
+	networkingv1ac "k8s.io/client-go/applyconfigurations/networking/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// Client reconciles the objects of deployed apps with server-side apply.
+// Every Apply* method declares the complete desired state of an object;
+// fields the deployer stops setting are removed and manual edits are
+// reported, and reverted where they touch fields the deployer sets.
 type Client struct {
 	clientset *kubernetes.Clientset
+	dynamic   dynamic.Interface
 }
 
 func NewClient() (*Client, error) {
@@ -41,251 +56,423 @@ func NewClient() (*Client, error) {
 			return nil, err
 		}
 	}
+	config.UserAgent = FieldManager
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Client{clientset: clientset}, nil
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{clientset: clientset, dynamic: dynamicClient}, nil
 }
 
 func (c *Client) EnsureNamespace(ctx context.Context, name string) error {
-	_, err := c.clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	_, err := c.clientset.CoreV1().Namespaces().Apply(ctx, corev1ac.Namespace(name), applyOptions)
+	return err
+}
+
+// ApplySecret stores the given values in an Opaque secret. Keys that are no
+// longer present are removed.
+func (c *Client) ApplySecret(ctx context.Context, namespace, name string, data map[string]string) error {
+	secrets := c.clientset.CoreV1().Secrets(namespace)
+
+	values := make(map[string][]byte, len(data))
+	for k, v := range data {
+		values[k] = []byte(v)
+	}
+
+	if existing, err := secrets.Get(ctx, name, metav1.GetOptions{}); err == nil {
+		reportDrift("Secret", existing)
+	}
+
+	secret := corev1ac.Secret(name, namespace).
+		WithType(corev1.SecretTypeOpaque).
+		WithData(values)
+	_, err := secrets.Apply(ctx, secret, applyOptions)
+	return err
+}
+
+// OwnSecret makes owner the owner of a secret, so the secret is removed with
+// it. It reports false if the secret does not exist.
+func (c *Client) OwnSecret(ctx context.Context, namespace, name string, owner *Owner) (bool, error) {
+	secrets := c.clientset.CoreV1().Secrets(namespace)
+
+	existing, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
 	if err != nil {
-		if errors.IsNotFound(err) {
-			// Create namespace
-			ns := &corev1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: name,
-				},
-			}
-			_, err = c.clientset.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
-			return err
+		return false, err
+	}
+	for _, ref := range existing.OwnerReferences {
+		if ref.UID == owner.UID {
+			return true, nil
 		}
-		return err
 	}
-	return nil
+
+	secret := corev1ac.Secret(name, namespace).WithOwnerReferences(owner.reference())
+	_, err = secrets.Apply(ctx, secret, metav1.ApplyOptions{FieldManager: ownerManager, Force: true})
+	return err == nil, err
 }
 
-func (c *Client) CreateDeployment(ctx context.Context, namespace, name, image string, env map[string]string) error {
-	replicas := int32(2)
-
-	envVars := []corev1.EnvVar{}
-	for k, v := range env {
-		envVars = append(envVars, corev1.EnvVar{
-			Name:  k,
-			Value: v,
-		})
-	}
-
-	deployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-			Labels: map[string]string{
-				"app": name,
-			},
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app": name,
-				},
-			},
-			Strategy: appsv1.DeploymentStrategy{
-				Type: appsv1.RollingUpdateDeploymentStrategyType,
-				RollingUpdate: &appsv1.RollingUpdateDeployment{
-					MaxSurge:       &intstr.IntOrString{Type: intstr.Int, IntVal: 1},
-					MaxUnavailable: &intstr.IntOrString{Type: intstr.Int, IntVal: 0},
-				},
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app": name,
-					},
-				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:  "app",
-							Image: image,
-							Ports: []corev1.ContainerPort{
-								{
-									ContainerPort: 80,
-									Protocol:      corev1.ProtocolTCP,
-								},
-							},
-							Env: envVars,
-							Resources: corev1.ResourceRequirements{
-								Requests: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse("100m"),
-									corev1.ResourceMemory: resource.MustParse("128Mi"),
-								},
-								Limits: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse("500m"),
-									corev1.ResourceMemory: resource.MustParse("512Mi"),
-								},
-							},
-						},
-					},
-				},
-			},
-		},
-	}
-
-	// Check if deployment exists
-	existing, err := c.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
-	if err != nil {
-		if errors.IsNotFound(err) {
-			// Create new deployment
-			_, err = c.clientset.AppsV1().Deployments(namespace).Create(ctx, deployment, metav1.CreateOptions{})
-			return err
+// Probe mirrors a project health check as stored in projects.health_checks
+type Probe struct {
+	Type                string `json:"type"` // http | tcp
+	Path                string `json:"path,omitempty"`
+	InitialDelaySeconds int32  `json:"initial_delay_seconds,omitempty"`
+	PeriodSeconds       int32  `json:"period_seconds,omitempty"`
+	TimeoutSeconds      int32  `json:"timeout_seconds,omitempty"`
+	FailureThreshold    int32  `json:"failure_threshold,omitempty"`
+}
+
+type HealthChecks struct {
+	Liveness  *Probe `json:"liveness,omitempty"`
+	Readiness *Probe `json:"readiness,omitempty"`
+	Startup   *Probe `json:"startup,omitempty"`
+}
+
+// Scaling mirrors a project's resolved plan as stored in projects.scaling.
+// CPU is in millicores, memory in MiB, targets in percent (0 = unused).
+type Scaling struct {
+	CPURequest    int64 `json:"cpu_request"`
+	CPULimit      int64 `json:"cpu_limit"`
+	MemoryRequest int64 `json:"memory_request"`
+	MemoryLimit   int64 `json:"memory_limit"`
+	MinReplicas   int32 `json:"min_replicas"`
+	MaxReplicas   int32 `json:"max_replicas"`
+	TargetCPU     int32 `json:"target_cpu"`
+	TargetMemory  int32 `json:"target_memory"`
+	ScaleToZero   bool  `json:"scale_to_zero"`
+}
+
+// AppSpec describes the container of an app Deployment
+type AppSpec struct {
+	Image string
+	Port  int32
+
+	// If set, every key of this secret is exposed as an env var
+	EnvSecret string
+
+	HealthChecks HealthChecks
+	Scaling      Scaling
+
+	// How long a rollout may take before Kubernetes reports it as failed
+	ProgressDeadline time.Duration
+
+	// Optional owner of the Deployment itself
+	Owner *Owner
+}
+
+// ApplyDeployment reconciles the app Deployment and returns it as the owner
+// of the deployment's other objects
+func (c *Client) ApplyDeployment(ctx context.Context, namespace, name string, spec AppSpec) (*Owner, error) {
+	deployments := c.clientset.AppsV1().Deployments(namespace)
+	labels := map[string]string{"app": name}
+
+	// Marks apps whose plan allows scaling them to zero while idle, for an
+	// idler to act on; the deployer itself keeps them running
+	annotations := map[string]string{}
+	if spec.Scaling.ScaleToZero {
+		annotations["dejavu.io/scale-to-zero"] = "true"
+	}
+
+	// Without an explicit readiness check, wait until the port accepts connections
+	readiness := spec.HealthChecks.Readiness
+	if readiness == nil {
+		readiness = &Probe{Type: "tcp"}
+	}
+
+	container := corev1ac.Container().
+		WithName("app").
+		WithImage(spec.Image).
+		WithPorts(corev1ac.ContainerPort().
+			WithContainerPort(spec.Port).
+			WithProtocol(corev1.ProtocolTCP)).
+		WithEnv(corev1ac.EnvVar().
+			WithName("PORT").
+			WithValue(strconv.Itoa(int(spec.Port)))).
+		WithResources(corev1ac.ResourceRequirements().
+			WithRequests(corev1.ResourceList{
+				corev1.ResourceCPU:    *resource.NewMilliQuantity(spec.Scaling.CPURequest, resource.DecimalSI),
+				corev1.ResourceMemory: *resource.NewQuantity(spec.Scaling.MemoryRequest<<20, resource.BinarySI),
+			}).
+			WithLimits(corev1.ResourceList{
+				corev1.ResourceCPU:    *resource.NewMilliQuantity(spec.Scaling.CPULimit, resource.DecimalSI),
+				corev1.ResourceMemory: *resource.NewQuantity(spec.Scaling.MemoryLimit<<20, resource.BinarySI),
+			})).
+		WithReadinessProbe(newProbe(readiness, spec.Port))
+
+	if spec.EnvSecret != "" {
+		container.WithEnvFrom(corev1ac.EnvFromSource().
+			WithSecretRef(corev1ac.SecretEnvSource().
+				WithName(spec.EnvSecret).
+				WithOptional(true)))
+	}
+	if spec.HealthChecks.Liveness != nil {
+		container.WithLivenessProbe(newProbe(spec.HealthChecks.Liveness, spec.Port))
+	}
+	if spec.HealthChecks.Startup != nil {
+		container.WithStartupProbe(newProbe(spec.HealthChecks.Startup, spec.Port))
+	}
+
+	deploymentSpec := appsv1ac.DeploymentSpec().
+		WithSelector(metav1ac.LabelSelector().WithMatchLabels(labels)).
+		WithStrategy(appsv1ac.DeploymentStrategy().
+			WithType(appsv1.RollingUpdateDeploymentStrategyType).
+			WithRollingUpdate(appsv1ac.RollingUpdateDeployment().
+				WithMaxSurge(intstr.FromInt(1)).
+				WithMaxUnavailable(intstr.FromInt(0)))).
+		WithTemplate(corev1ac.PodTemplateSpec().
+			WithLabels(labels).
+			WithSpec(corev1ac.PodSpec().WithContainers(container)))
+
+	if spec.ProgressDeadline > 0 {
+		deploymentSpec.WithProgressDeadlineSeconds(int32(spec.ProgressDeadline.Seconds()))
+	}
+
+	// With an autoscaler the HPA sets the replica count. The apply keeps the
+	// current one, clamped to the new range: leaving the field out would give
+	// up its ownership and reset it to 1 until the HPA scales again.
+	replicas := spec.Scaling.MinReplicas
+	if existing, err := deployments.Get(ctx, name, metav1.GetOptions{}); err == nil {
+		reportDrift("Deployment", existing)
+		if current := existing.Spec.Replicas; current != nil && spec.Scaling.MaxReplicas > spec.Scaling.MinReplicas {
+			replicas = min(max(*current, spec.Scaling.MinReplicas), spec.Scaling.MaxReplicas)
 		}
-		return err
+	}
+	deploymentSpec.WithReplicas(replicas)
+
+	deployment := appsv1ac.Deployment(name, namespace).
+		WithLabels(labels).
+		WithAnnotations(annotations).
+		WithSpec(deploymentSpec)
+	if spec.Owner != nil {
+		deployment.WithOwnerReferences(spec.Owner.reference())
 	}
 
-	// Update existing deployment
-	existing.Spec = deployment.Spec
-	_, err = c.clientset.AppsV1().Deployments(namespace).Update(ctx, existing, metav1.UpdateOptions{})
+	applied, err := deployments.Apply(ctx, deployment, applyOptions)
+	if err != nil {
+		return nil, err
+	}
+	return ownerOf(applied), nil
+}
+
+func newProbe(p *Probe, port int32) *corev1ac.ProbeApplyConfiguration {
+	probe := corev1ac.Probe()
+	if p.InitialDelaySeconds > 0 {
+		probe.WithInitialDelaySeconds(p.InitialDelaySeconds)
+	}
+	if p.PeriodSeconds > 0 {
+		probe.WithPeriodSeconds(p.PeriodSeconds)
+	}
+	if p.TimeoutSeconds > 0 {
+		probe.WithTimeoutSeconds(p.TimeoutSeconds)
+	}
+	if p.FailureThreshold > 0 {
+		probe.WithFailureThreshold(p.FailureThreshold)
+	}
+
+	if p.Type == "http" {
+		probe.WithHTTPGet(corev1ac.HTTPGetAction().
+			WithPath(p.Path).
+			WithPort(intstr.FromInt(int(port))))
+	} else {
+		probe.WithTCPSocket(corev1ac.TCPSocketAction().
+			WithPort(intstr.FromInt(int(port))))
+	}
+	return probe
+}
+
+// ApplyService exposes the app's targetPort on port inside the cluster
+func (c *Client) ApplyService(ctx context.Context, namespace, name string, port, targetPort int32, owner *Owner) error {
+	services := c.clientset.CoreV1().Services(namespace)
+	labels := map[string]string{"app": name}
+
+	if existing, err := services.Get(ctx, name, metav1.GetOptions{}); err == nil {
+		reportDrift("Service", existing)
+	}
+
+	service := corev1ac.Service(name, namespace).
+		WithLabels(labels).
+		WithOwnerReferences(owner.reference()).
+		WithSpec(corev1ac.ServiceSpec().
+			WithType(corev1.ServiceTypeClusterIP).
+			WithSelector(labels).
+			WithPorts(corev1ac.ServicePort().
+				WithProtocol(corev1.ProtocolTCP).
+				WithPort(port).
+				WithTargetPort(intstr.FromInt(int(targetPort)))))
+
+	_, err := services.Apply(ctx, service, applyOptions)
 	return err
 }
 
-func (c *Client) CreateService(ctx context.Context, namespace, name string, port int32) error {
-	service := &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-			Labels: map[string]string{
-				"app": name,
-			},
-		},
-		Spec: corev1.ServiceSpec{
-			Selector: map[string]string{
-				"app": name,
-			},
-			Ports: []corev1.ServicePort{
-				{
-					Protocol:   corev1.ProtocolTCP,
-					Port:       port,
-					TargetPort: intstr.FromInt(80),
-				},
-			},
-			Type: corev1.ServiceTypeClusterIP,
-		},
-	}
-
-	_, err := c.clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
-	if err != nil {
-		if errors.IsNotFound(err) {
-			_, err = c.clientset.CoreV1().Services(namespace).Create(ctx, service, metav1.CreateOptions{})
-			return err
-		}
-		return err
+// ApplyIngress routes host to serviceName. Aliases that move between
+// deployments, like the production domain, are applied without an owner.
+func (c *Client) ApplyIngress(ctx context.Context, namespace, name, host, serviceName string, servicePort int32, owner *Owner) error {
+	ingress := newIngress(name, namespace, []string{host}, serviceName, servicePort)
+	if owner != nil {
+		ingress.WithOwnerReferences(owner.reference())
+	}
+	return c.applyIngress(ctx, ingress)
+}
+
+// ApplyDomainIngress routes every host to serviceName and asks cert-manager
+// (via the given ClusterIssuer) for a certificate covering all of them. With
+// no hosts left the ingress is removed.
+func (c *Client) ApplyDomainIngress(ctx context.Context, namespace, name string, hosts []string, serviceName string, servicePort int32, issuer string, owner *Owner) error {
+	if len(hosts) == 0 {
+		return c.DeleteIngress(ctx, namespace, name)
+	}
+
+	ingress := newIngress(name, namespace, hosts, serviceName, servicePort).
+		WithAnnotations(map[string]string{"cert-manager.io/cluster-issuer": issuer})
+	ingress.Spec.WithTLS(networkingv1ac.IngressTLS().
+		WithHosts(hosts...).
+		WithSecretName(name + "-tls"))
+	if owner != nil {
+		ingress.WithOwnerReferences(owner.reference())
+	}
+
+	return c.applyIngress(ctx, ingress)
+}
+
+func (c *Client) applyIngress(ctx context.Context, ingress *networkingv1ac.IngressApplyConfiguration) error {
+	ingresses := c.clientset.NetworkingV1().Ingresses(*ingress.Namespace)
+
+	if existing, err := ingresses.Get(ctx, *ingress.Name, metav1.GetOptions{}); err == nil {
+		reportDrift("Ingress", existing)
+	}
+
+	_, err := ingresses.Apply(ctx, ingress, applyOptions)
+	return err
+}
+
+func newIngress(name, namespace string, hosts []string, serviceName string, servicePort int32) *networkingv1ac.IngressApplyConfiguration {
+	spec := networkingv1ac.IngressSpec()
+	for _, host := range hosts {
+		spec.WithRules(networkingv1ac.IngressRule().
+			WithHost(host).
+			WithHTTP(networkingv1ac.HTTPIngressRuleValue().
+				WithPaths(networkingv1ac.HTTPIngressPath().
+					WithPath("/").
+					WithPathType(networkingv1.PathTypePrefix).
+					WithBackend(networkingv1ac.IngressBackend().
+						WithService(networkingv1ac.IngressServiceBackend().
+							WithName(serviceName).
+							WithPort(networkingv1ac.ServiceBackendPort().WithNumber(servicePort)))))))
 	}
 
-	// Service already exists
-	return nil
+	return networkingv1ac.Ingress(name, namespace).
+		WithAnnotations(map[string]string{
+			"kubernetes.io/ingress.class": "traefik",
+		}).
+		WithSpec(spec)
 }
 
-func (c *Client) CreateIngress(ctx context.Context, namespace, name, host, serviceName string, servicePort int32) error {
-	pathType := networkingv1.PathTypePrefix
-
-	ingress := &networkingv1.Ingress{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-			Annotations: map[string]string{
-				"kubernetes.io/ingress.class": "traefik",
-			},
-		},
-		Spec: networkingv1.IngressSpec{
-			Rules: []networkingv1.IngressRule{
-				{
-					Host: host,
-					IngressRuleValue: networkingv1.IngressRuleValue{
-						HTTP: &networkingv1.HTTPIngressRuleValue{
-							Paths: []networkingv1.HTTPIngressPath{
-								{
-									Path:     "/",
-									PathType: &pathType,
-									Backend: networkingv1.IngressBackend{
-										Service: &networkingv1.IngressServiceBackend{
-											Name: serviceName,
-											Port: networkingv1.ServiceBackendPort{
-												Number: servicePort,
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
-	}
-
-	_, err := c.clientset.NetworkingV1().Ingresses(namespace).Get(ctx, name, metav1.GetOptions{})
+// CertificateHosts returns the DNS names of the certificate cert-manager
+// stored in the named secret, or nil while it has not been issued yet
+func (c *Client) CertificateHosts(ctx context.Context, namespace, name string) ([]string, error) {
+	secret, err := c.clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
-			_, err = c.clientset.NetworkingV1().Ingresses(namespace).Create(ctx, ingress, metav1.CreateOptions{})
-			return err
+			return nil, nil
 		}
-		return err
+		return nil, err
 	}
 
-	// Ingress already exists
-	return nil
+	block, _ := pem.Decode(secret.Data[corev1.TLSCertKey])
+	if block == nil {
+		return nil, nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return cert.DNSNames, nil
 }
 
-func (c *Client) CreateHPA(ctx context.Context, namespace, name string, minReplicas, maxReplicas int32) error {
-	targetCPU := int32(80)
-
-	hpa := &autoscalingv2.HorizontalPodAutoscaler{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-		},
-		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
-			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
-				APIVersion: "apps/v1",
-				Kind:       "Deployment",
-				Name:       name,
-			},
-			MinReplicas: &minReplicas,
-			MaxReplicas: maxReplicas,
-			Metrics: []autoscalingv2.MetricSpec{
-				{
-					Type: autoscalingv2.ResourceMetricSourceType,
-					Resource: &autoscalingv2.ResourceMetricSource{
-						Name: corev1.ResourceCPU,
-						Target: autoscalingv2.MetricTarget{
-							Type:               autoscalingv2.UtilizationMetricType,
-							AverageUtilization: &targetCPU,
-						},
-					},
-				},
-			},
-		},
-	}
-
-	_, err := c.clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).Get(ctx, name, metav1.GetOptions{})
-	if err != nil {
+// ApplyHPA reconciles the autoscaler of an app Deployment. Without room to
+// scale (min == max) any existing autoscaler is removed.
+func (c *Client) ApplyHPA(ctx context.Context, namespace, name string, scaling Scaling, owner *Owner) error {
+	hpas := c.clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace)
+
+	if scaling.MaxReplicas <= scaling.MinReplicas {
+		err := hpas.Delete(ctx, name, metav1.DeleteOptions{})
 		if errors.IsNotFound(err) {
-			_, err = c.clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).Create(ctx, hpa, metav1.CreateOptions{})
-			return err
+			return nil
 		}
 		return err
 	}
 
-	// HPA already exists
-	return nil
+	spec := autoscalingv2ac.HorizontalPodAutoscalerSpec().
+		WithScaleTargetRef(autoscalingv2ac.CrossVersionObjectReference().
+			WithAPIVersion("apps/v1").
+			WithKind("Deployment").
+			WithName(name)).
+		WithMinReplicas(scaling.MinReplicas).
+		WithMaxReplicas(scaling.MaxReplicas)
+
+	for _, target := range []struct {
+		resource corev1.ResourceName
+		percent  int32
+	}{
+		{corev1.ResourceCPU, scaling.TargetCPU},
+		{corev1.ResourceMemory, scaling.TargetMemory},
+	} {
+		if target.percent <= 0 {
+			continue
+		}
+		spec.WithMetrics(autoscalingv2ac.MetricSpec().
+			WithType(autoscalingv2.ResourceMetricSourceType).
+			WithResource(autoscalingv2ac.ResourceMetricSource().
+				WithName(target.resource).
+				WithTarget(autoscalingv2ac.MetricTarget().
+					WithType(autoscalingv2.UtilizationMetricType).
+					WithAverageUtilization(target.percent))))
+	}
+
+	if existing, err := hpas.Get(ctx, name, metav1.GetOptions{}); err == nil {
+		reportDrift("HorizontalPodAutoscaler", existing)
+	}
+
+	hpa := autoscalingv2ac.HorizontalPodAutoscaler(name, namespace).
+		WithOwnerReferences(owner.reference()).
+		WithSpec(spec)
+	_, err := hpas.Apply(ctx, hpa, applyOptions)
+	return err
+}
+
+// DeleteIngress removes an ingress, ignoring ones that are already gone
+func (c *Client) DeleteIngress(ctx context.Context, namespace, name string) error {
+	err := c.clientset.NetworkingV1().Ingresses(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
 }
 
+// DeleteSecret removes a secret, ignoring ones that are already gone
+func (c *Client) DeleteSecret(ctx context.Context, namespace, name string) error {
+	err := c.clientset.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// DeleteDeployment removes an app Deployment. Its Service, Ingress and HPA
+// are garbage-collected through their owner references; they are deleted
+// explicitly as well for objects created before owner references were set.
 func (c *Client) DeleteDeployment(ctx context.Context, namespace, name string) error {
+	// Delete env Secret
+	c.clientset.CoreV1().Secrets(namespace).Delete(ctx, name+"-env", metav1.DeleteOptions{})
+
 	// Delete HPA
 	c.clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).Delete(ctx, name, metav1.DeleteOptions{})
 
@@ -296,5 +483,12 @@ func (c *Client) DeleteDeployment(ctx context.Context, namespace, name string) e
 	c.clientset.CoreV1().Services(namespace).Delete(ctx, name, metav1.DeleteOptions{})
 
 	// Delete Deployment
-	return c.clientset.AppsV1().Deployments(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	propagation := metav1.DeletePropagationBackground
+	err := c.clientset.AppsV1().Deployments(namespace).Delete(ctx, name, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
 }
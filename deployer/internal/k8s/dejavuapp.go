@@ -0,0 +1,229 @@
+package k8s
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// AppResource is the DejavuApp custom resource, see
+// infra/kubernetes/crds/dejavuapp.yaml
+var AppResource = schema.GroupVersionResource{Group: "dejavu.io", Version: "v1alpha1", Resource: "dejavuapps"}
+
+const (
+	appAPIVersion = "dejavu.io/v1alpha1"
+	appKind       = "DejavuApp"
+)
+
+// DejavuApp describes everything that should run in the cluster for one
+// project. In controller mode the deployer writes it from Postgres and a
+// controller converges the cluster to it.
+type DejavuApp struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DejavuAppSpec   `json:"spec"`
+	Status DejavuAppStatus `json:"status,omitempty"`
+}
+
+type DejavuAppSpec struct {
+	ProjectID string `json:"projectID"`
+
+	// Production host, custom domains and the deployment they route to once
+	// it is available
+	Host       string   `json:"host"`
+	Production string   `json:"production,omitempty"`
+	Domains    []string `json:"domains,omitempty"`
+	CertIssuer string   `json:"certIssuer,omitempty"`
+
+	Deployments []AppDeployment `json:"deployments,omitempty"`
+}
+
+// AppDeployment is one deployment of the project kept running in the cluster
+type AppDeployment struct {
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	Host         string       `json:"host"`
+	Image        string       `json:"image"`
+	Port         int32        `json:"port"`
+	EnvSecret    string       `json:"envSecret,omitempty"`
+	HealthChecks HealthChecks `json:"healthChecks,omitempty"`
+	Scaling      Scaling      `json:"scaling"`
+}
+
+type DejavuAppStatus struct {
+	// Deployment the production host currently routes to
+	Serving            string `json:"serving,omitempty"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+}
+
+// Owner makes an object part of the app, so deleting the DejavuApp removes it
+func (a *DejavuApp) Owner() *Owner {
+	return &Owner{APIVersion: appAPIVersion, Kind: appKind, Name: a.Name, UID: a.UID}
+}
+
+// ApplyApp creates or updates the spec of a DejavuApp
+func (c *Client) ApplyApp(ctx context.Context, namespace, name string, spec DejavuAppSpec) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&spec)
+	if err != nil {
+		return err
+	}
+
+	obj := newAppObject(namespace, name)
+	obj.Object["spec"] = content
+	_, err = c.dynamic.Resource(AppResource).Namespace(namespace).Apply(ctx, name, obj, applyOptions)
+	return err
+}
+
+// UpdateAppStatus writes the status subresource of a DejavuApp
+func (c *Client) UpdateAppStatus(ctx context.Context, namespace, name string, status DejavuAppStatus) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+
+	obj := newAppObject(namespace, name)
+	obj.Object["status"] = content
+	_, err = c.dynamic.Resource(AppResource).Namespace(namespace).ApplyStatus(ctx, name, obj, applyOptions)
+	return err
+}
+
+// GetApp returns the named DejavuApp, or nil if it does not exist
+func (c *Client) GetApp(ctx context.Context, namespace, name string) (*DejavuApp, error) {
+	obj, err := c.dynamic.Resource(AppResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var app DejavuApp
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &app); err != nil {
+		return nil, err
+	}
+	return &app, nil
+}
+
+// DeleteApp removes a DejavuApp; Kubernetes garbage-collects everything it
+// owns
+func (c *Client) DeleteApp(ctx context.Context, namespace, name string) error {
+	propagation := metav1.DeletePropagationBackground
+	err := c.dynamic.Resource(AppResource).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// AppDeployments lists the names of the app Deployments owned by a DejavuApp
+func (c *Client) AppDeployments(ctx context.Context, namespace string, app *DejavuApp) ([]string, error) {
+	list, err := c.clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, d := range list.Items {
+		for _, ref := range d.OwnerReferences {
+			if ref.UID == app.UID {
+				names = append(names, d.Name)
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+// WatchApps calls enqueue with the name of a DejavuApp whenever it or one of
+// the objects it (indirectly) owns changes, and periodically for every app.
+// It returns once the caches are synced; watching stops with ctx.
+func (c *Client) WatchApps(ctx context.Context, namespace string, resync time.Duration, enqueue func(name string)) error {
+	appFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamic, resync, namespace, nil)
+	appInformer := appFactory.ForResource(AppResource).Informer()
+	appInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueueObject(obj, enqueue) },
+		UpdateFunc: func(_, obj interface{}) { enqueueObject(obj, enqueue) },
+	})
+
+	factory := informers.NewSharedInformerFactoryWithOptions(c.clientset, resync, informers.WithNamespace(namespace))
+	deployments := factory.Apps().V1().Deployments()
+
+	// Objects are owned either by the app or by an app Deployment
+	enqueueOwner := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		object, ok := obj.(metav1.Object)
+		if !ok {
+			return
+		}
+		ref := metav1.GetControllerOf(object)
+		if ref == nil {
+			return
+		}
+		switch ref.Kind {
+		case appKind:
+			enqueue(ref.Name)
+		case "Deployment":
+			deployment, err := deployments.Lister().Deployments(namespace).Get(ref.Name)
+			if err != nil {
+				return
+			}
+			if owner := metav1.GetControllerOf(deployment); owner != nil && owner.Kind == appKind {
+				enqueue(owner.Name)
+			}
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, obj interface{}) { enqueueOwner(obj) },
+		DeleteFunc: enqueueOwner,
+	}
+
+	for _, informer := range []cache.SharedIndexInformer{
+		deployments.Informer(),
+		factory.Core().V1().Services().Informer(),
+		factory.Networking().V1().Ingresses().Informer(),
+		factory.Autoscaling().V2().HorizontalPodAutoscalers().Informer(),
+	} {
+		informer.AddEventHandler(handler)
+	}
+
+	appFactory.Start(ctx.Done())
+	factory.Start(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), appInformer.HasSynced) {
+		return ctx.Err()
+	}
+	for _, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func enqueueObject(obj interface{}, enqueue func(name string)) {
+	if object, ok := obj.(metav1.Object); ok {
+		enqueue(object.GetName())
+	}
+}
+
+func newAppObject(namespace, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	obj.SetAPIVersion(appAPIVersion)
+	obj.SetKind(appKind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
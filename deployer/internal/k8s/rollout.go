@@ -0,0 +1,191 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// Container waiting reasons that will not resolve on their own
+var fatalWaitingReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// RolloutError explains why a Deployment did not become available
+type RolloutError struct {
+	Reason string
+	Detail string
+}
+
+func (e *RolloutError) Error() string {
+	if e.Detail == "" {
+		return e.Reason
+	}
+	return e.Reason + "\n" + e.Detail
+}
+
+// WaitForRollout blocks until every replica of the Deployment runs the
+// current spec and is available. It fails early when a pod hits a fatal
+// state such as an image pull error or a crash loop, and otherwise after
+// timeout. Failures are returned as *RolloutError.
+func (c *Client) WaitForRollout(ctx context.Context, namespace, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	timedOut := func() error {
+		return &RolloutError{
+			Reason: fmt.Sprintf("rollout did not complete within %s", timeout),
+			Detail: c.podDiagnostics(context.Background(), namespace, name),
+		}
+	}
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		done, err := c.RolloutStatus(ctx, namespace, name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return timedOut()
+			}
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return timedOut()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RolloutStatus reports whether the Deployment's rollout is complete without
+// waiting. A rollout that can no longer succeed is returned as *RolloutError.
+func (c *Client) RolloutStatus(ctx context.Context, namespace, name string) (bool, error) {
+	deployment, err := c.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	if rolloutComplete(deployment) {
+		return true, nil
+	}
+
+	for _, cond := range deployment.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+			return false, &RolloutError{Reason: cond.Message, Detail: c.podDiagnostics(context.Background(), namespace, name)}
+		}
+	}
+
+	if rolloutErr := c.checkPods(ctx, namespace, name); rolloutErr != nil {
+		return false, rolloutErr
+	}
+	return false, nil
+}
+
+func rolloutComplete(d *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+
+	return d.Status.ObservedGeneration >= d.Generation &&
+		d.Status.UpdatedReplicas >= replicas &&
+		d.Status.AvailableReplicas >= replicas &&
+		d.Status.UnavailableReplicas == 0
+}
+
+// checkPods returns an error as soon as one of the Deployment's pods is stuck
+// in a state that will not recover
+func (c *Client) checkPods(ctx context.Context, namespace, name string) *RolloutError {
+	pods, err := c.listPods(ctx, namespace, name)
+	if err != nil {
+		return nil
+	}
+
+	for _, pod := range pods {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Waiting == nil || !fatalWaitingReasons[status.State.Waiting.Reason] {
+				continue
+			}
+
+			reason := fmt.Sprintf("container %s in pod %s: %s", status.Name, pod.Name, status.State.Waiting.Reason)
+			if msg := status.State.Waiting.Message; msg != "" {
+				reason += ": " + msg
+			}
+			return &RolloutError{Reason: reason, Detail: c.podDiagnostics(context.Background(), namespace, name)}
+		}
+	}
+	return nil
+}
+
+// podDiagnostics collects warning events and the last container logs of the
+// Deployment's pods for the deployment's error message
+func (c *Client) podDiagnostics(ctx context.Context, namespace, name string) string {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	pods, err := c.listPods(ctx, namespace, name)
+	if err != nil || len(pods) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, pod := range pods {
+		events, err := c.clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("involvedObject.name", pod.Name).String(),
+		})
+		if err == nil {
+			for _, event := range events.Items {
+				if event.Type == corev1.EventTypeWarning {
+					fmt.Fprintf(&b, "[%s] %s: %s\n", pod.Name, event.Reason, event.Message)
+				}
+			}
+		}
+
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.RestartCount == 0 {
+				continue
+			}
+			tail := int64(20)
+			logs, err := c.clientset.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+				Container: status.Name,
+				Previous:  true,
+				TailLines: &tail,
+			}).DoRaw(ctx)
+			if err == nil && len(logs) > 0 {
+				fmt.Fprintf(&b, "[%s] last logs of %s:\n%s\n", pod.Name, status.Name, strings.TrimRight(string(logs), "\n"))
+			}
+		}
+
+		// One pod is usually enough to explain the failure
+		if b.Len() > 0 {
+			break
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func (c *Client) listPods(ctx context.Context, namespace, name string) ([]corev1.Pod, error) {
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app=" + name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
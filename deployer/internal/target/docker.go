@@ -0,0 +1,360 @@
+package target
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Restarts after which a container counts as crash looping
+	maxRestarts = 3
+
+	// How long a replacement container may take to accept connections
+	// before the running one is kept
+	replaceTimeout = 5 * time.Minute
+)
+
+// Docker runs every app as a single local container and serves the routes
+// through a built-in reverse proxy. Meant for development without a cluster:
+// scaling settings beyond CPU and memory limits and TLS are ignored.
+type Docker struct {
+	proxyAddr  string
+	routesFile string
+
+	mu     sync.RWMutex
+	routes map[string]Route  // by route name
+	hosts  map[string]string // host -> app
+	apps   map[string]string // app -> upstream address
+
+	startProxy sync.Once
+	proxyErr   error
+}
+
+// NewDocker loads the routes saved in routesFile, so they survive restarts
+func NewDocker(proxyAddr, routesFile string) (*Docker, error) {
+	t := &Docker{
+		proxyAddr:  proxyAddr,
+		routesFile: routesFile,
+		routes:     map[string]Route{},
+		apps:       map[string]string{},
+	}
+
+	data, err := os.ReadFile(routesFile)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &t.routes); err != nil {
+			return nil, fmt.Errorf("invalid routes file %s: %w", routesFile, err)
+		}
+	}
+	t.indexHosts()
+
+	return t, nil
+}
+
+// Ensure checks that the Docker daemon is reachable and starts the proxy
+func (t *Docker) Ensure(ctx context.Context) error {
+	if _, err := docker(ctx, "version", "--format", "{{.Server.Version}}"); err != nil {
+		return err
+	}
+
+	t.startProxy.Do(func() {
+		var listener net.Listener
+		listener, t.proxyErr = net.Listen("tcp", t.proxyAddr)
+		if t.proxyErr != nil {
+			return
+		}
+		go func() {
+			if err := http.Serve(listener, t); err != nil {
+				log.Printf("Docker target proxy stopped: %v", err)
+			}
+		}()
+	})
+	return t.proxyErr
+}
+
+// Deploy starts the app's container. The container port is published on a
+// random loopback port that the proxy forwards to. A running container of the
+// app keeps serving until its replacement accepts connections; the proxy then
+// switches over and the replacement takes the old container's name.
+func (t *Docker) Deploy(ctx context.Context, app App) error {
+	name := app.Name
+	replacing, err := containerExists(ctx, app.Name)
+	if err != nil {
+		return err
+	}
+	if replacing {
+		name = app.Name + "-next"
+		if err := removeContainer(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	args := []string{
+		"run", "--detach",
+		"--name", name,
+		"--label", "dejavu.app=" + app.Name,
+		"--restart", "on-failure",
+		"--publish", fmt.Sprintf("127.0.0.1::%d", app.Port),
+		"--env", fmt.Sprintf("PORT=%d", app.Port),
+	}
+	if app.Scaling.CPULimit > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(float64(app.Scaling.CPULimit)/1000, 'f', 3, 64))
+	}
+	if app.Scaling.MemoryLimit > 0 {
+		args = append(args, "--memory", fmt.Sprintf("%dm", app.Scaling.MemoryLimit))
+	}
+
+	// Pass env vars through a file so they do not show up in process lists
+	if len(app.Env) > 0 {
+		envFile, err := writeEnvFile(app.Env)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(envFile)
+		args = append(args, "--env-file", envFile)
+	}
+
+	args = append(args, app.Image)
+	if _, err := docker(ctx, args...); err != nil {
+		return err
+	}
+
+	output, err := docker(ctx, "port", name, fmt.Sprintf("%d/tcp", app.Port))
+	if err != nil {
+		return err
+	}
+	upstream := strings.TrimSpace(strings.SplitN(output, "\n", 2)[0])
+
+	if replacing {
+		if err := t.awaitContainer(ctx, name, upstream); err != nil {
+			removeContainer(ctx, name)
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	t.apps[app.Name] = upstream
+	t.mu.Unlock()
+
+	if replacing {
+		if err := removeContainer(ctx, app.Name); err != nil {
+			return err
+		}
+		if _, err := docker(ctx, "rename", name, app.Name); err != nil {
+			return err
+		}
+	}
+
+	return t.Expose(ctx, Route{Name: app.Name, Hosts: []string{app.Host}, App: app.Name})
+}
+
+// Status reports a running container as available once its port accepts
+// connections
+func (t *Docker) Status(ctx context.Context, name string) (bool, error) {
+	upstream := func() (string, error) { return t.upstream(ctx, name) }
+	return t.containerStatus(ctx, name, upstream)
+}
+
+// awaitContainer waits until a container accepts connections on upstream
+func (t *Docker) awaitContainer(ctx context.Context, container, upstream string) error {
+	ctx, cancel := context.WithTimeout(ctx, replaceTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	address := func() (string, error) { return upstream, nil }
+	for {
+		done, err := t.containerStatus(ctx, container, address)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("replacement container did not become available within %s", replaceTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *Docker) containerStatus(ctx context.Context, name string, address func() (string, error)) (bool, error) {
+	output, err := docker(ctx, "inspect", "--format", "{{.State.Status}} {{.RestartCount}}", name)
+	if err != nil {
+		return false, err
+	}
+
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return false, fmt.Errorf("unexpected container state %q", output)
+	}
+	restarts, _ := strconv.Atoi(fields[1])
+
+	switch {
+	case fields[0] == "exited" || fields[0] == "dead":
+		return false, fmt.Errorf("container %s: %s", fields[0], t.logs(ctx, name))
+	case restarts >= maxRestarts:
+		return false, fmt.Errorf("container keeps restarting (%d restarts): %s", restarts, t.logs(ctx, name))
+	case fields[0] != "running":
+		return false, nil
+	}
+
+	upstream, err := address()
+	if err != nil {
+		return false, err
+	}
+
+	conn, err := net.DialTimeout("tcp", upstream, time.Second)
+	if err != nil {
+		return false, nil
+	}
+	conn.Close()
+	return true, nil
+}
+
+// Expose saves the route; the proxy picks it up immediately
+func (t *Docker) Expose(ctx context.Context, route Route) error {
+	t.mu.Lock()
+	if len(route.Hosts) == 0 {
+		delete(t.routes, route.Name)
+	} else {
+		t.routes[route.Name] = route
+	}
+	t.indexHosts()
+	data, err := json.MarshalIndent(t.routes, "", "  ")
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(t.routesFile, data, 0644)
+}
+
+// Delete removes the app's container and its preview route
+func (t *Docker) Delete(ctx context.Context, name string) error {
+	for _, container := range []string{name, name + "-next"} {
+		if err := removeContainer(ctx, container); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	delete(t.apps, name)
+	t.mu.Unlock()
+
+	return t.Expose(ctx, Route{Name: name})
+}
+
+// upstream returns the published address of an app's container
+func (t *Docker) upstream(ctx context.Context, name string) (string, error) {
+	t.mu.RLock()
+	upstream, ok := t.apps[name]
+	t.mu.RUnlock()
+	if ok {
+		return upstream, nil
+	}
+
+	// Containers started before a restart of the deployer
+	output, err := docker(ctx, "inspect", "--format", "{{range $p, $b := .NetworkSettings.Ports}}{{range $b}}{{.HostIp}}:{{.HostPort}}{{end}}{{end}}", name)
+	if err != nil {
+		return "", err
+	}
+	upstream = strings.TrimSpace(output)
+	if upstream == "" {
+		return "", fmt.Errorf("container %s has no published port", name)
+	}
+
+	t.mu.Lock()
+	t.apps[name] = upstream
+	t.mu.Unlock()
+	return upstream, nil
+}
+
+func (t *Docker) logs(ctx context.Context, name string) string {
+	output, err := docker(ctx, "logs", "--tail", "20", name)
+	if err != nil {
+		return err.Error()
+	}
+	return strings.TrimSpace(output)
+}
+
+// indexHosts rebuilds the host lookup of the proxy; callers hold mu
+func (t *Docker) indexHosts() {
+	names := make([]string, 0, len(t.routes))
+	for name := range t.routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	t.hosts = map[string]string{}
+	for _, name := range names {
+		for _, host := range t.routes[name].Hosts {
+			t.hosts[host] = t.routes[name].App
+		}
+	}
+}
+
+func containerExists(ctx context.Context, name string) (bool, error) {
+	_, err := docker(ctx, "inspect", "--format", "{{.Id}}", name)
+	if err != nil && strings.Contains(err.Error(), "No such") {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func removeContainer(ctx context.Context, name string) error {
+	if _, err := docker(ctx, "rm", "--force", name); err != nil && !strings.Contains(err.Error(), "No such container") {
+		return err
+	}
+	return nil
+}
+
+func writeEnvFile(env map[string]string) (string, error) {
+	file, err := os.CreateTemp("", "dejavu-env-*")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	for key, value := range env {
+		// Env files cannot hold multi-line values
+		if strings.ContainsAny(value, "\r\n") {
+			os.Remove(file.Name())
+			return "", fmt.Errorf("env var %s: multi-line values are not supported by the docker target", key)
+		}
+		if _, err := fmt.Fprintf(file, "%s=%s\n", key, value); err != nil {
+			os.Remove(file.Name())
+			return "", err
+		}
+	}
+	return file.Name(), nil
+}
+
+func docker(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
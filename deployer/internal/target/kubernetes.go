@@ -0,0 +1,94 @@
+package target
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/dejavu/deployer/internal/k8s"
+)
+
+// Kubernetes runs every app as a Deployment with a Service, an Ingress and
+// optionally an HPA in one namespace
+type Kubernetes struct {
+	client     *k8s.Client
+	namespace  string
+	certIssuer string
+}
+
+func NewKubernetes(client *k8s.Client, namespace, certIssuer string) *Kubernetes {
+	return &Kubernetes{client: client, namespace: namespace, certIssuer: certIssuer}
+}
+
+func (t *Kubernetes) Ensure(ctx context.Context) error {
+	return t.client.EnsureNamespace(ctx, t.namespace)
+}
+
+func (t *Kubernetes) Deploy(ctx context.Context, app App) error {
+	// Runtime env vars are stored as a secret
+	envSecret := ""
+	if len(app.Env) > 0 {
+		envSecret = app.Name + "-env"
+		if err := t.client.ApplySecret(ctx, t.namespace, envSecret, app.Env); err != nil {
+			return err
+		}
+	}
+
+	spec := k8s.AppSpec{
+		Image:        app.Image,
+		Port:         app.Port,
+		EnvSecret:    envSecret,
+		HealthChecks: app.HealthChecks,
+		Scaling:      app.Scaling,
+	}
+	owner, err := t.client.ApplyDeployment(ctx, t.namespace, app.Name, spec)
+	if err != nil {
+		return err
+	}
+
+	if err := t.client.ApplyService(ctx, t.namespace, app.Name, 80, app.Port, owner); err != nil {
+		return err
+	}
+
+	if err := t.client.ApplyIngress(ctx, t.namespace, app.Name, app.Host, app.Name, 80, owner); err != nil {
+		return err
+	}
+
+	if err := t.client.ApplyHPA(ctx, t.namespace, app.Name, app.Scaling, owner); err != nil {
+		log.Printf("Error applying HPA: %v", err)
+		// HPA is optional, continue anyway
+	}
+	return nil
+}
+
+func (t *Kubernetes) Status(ctx context.Context, name string) (bool, error) {
+	return t.client.RolloutStatus(ctx, t.namespace, name)
+}
+
+// Wait adds pod events and logs to timeouts
+func (t *Kubernetes) Wait(ctx context.Context, name string, timeout time.Duration) error {
+	return t.client.WaitForRollout(ctx, t.namespace, name, timeout)
+}
+
+// Expose maps a route to an Ingress of the same name. TLS routes get a
+// certificate from cert-manager.
+func (t *Kubernetes) Expose(ctx context.Context, route Route) error {
+	if route.TLS {
+		if err := t.client.ApplyDomainIngress(ctx, t.namespace, route.Name, route.Hosts, route.App, 80, t.certIssuer, nil); err != nil {
+			return err
+		}
+		if len(route.Hosts) == 0 {
+			return t.client.DeleteSecret(ctx, t.namespace, route.Name+"-tls")
+		}
+		return nil
+	}
+
+	if len(route.Hosts) == 0 {
+		return t.client.DeleteIngress(ctx, t.namespace, route.Name)
+	}
+	return t.client.ApplyIngress(ctx, t.namespace, route.Name, route.Hosts[0], route.App, 80, nil)
+}
+
+func (t *Kubernetes) Delete(ctx context.Context, name string) error {
+	return t.client.DeleteDeployment(ctx, t.namespace, name)
+}
@@ -0,0 +1,34 @@
+package target
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// ServeHTTP forwards requests to the container of the app routed to the
+// request's host
+func (t *Docker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	t.mu.RLock()
+	app, ok := t.hosts[host]
+	t.mu.RUnlock()
+	if !ok {
+		http.Error(w, "no app is deployed at "+host, http.StatusNotFound)
+		return
+	}
+
+	upstream, err := t.upstream(r.Context(), app)
+	if err != nil {
+		http.Error(w, "app "+app+" is not running", http.StatusBadGateway)
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: upstream})
+	proxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,80 @@
+package target
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dejavu/deployer/internal/k8s"
+)
+
+// App is one deployment as it should run on a target
+type App struct {
+	Name         string
+	Image        string
+	Host         string // preview host of the deployment
+	Port         int32
+	Env          map[string]string
+	HealthChecks k8s.HealthChecks
+	Scaling      k8s.Scaling
+}
+
+// Route sends traffic for Hosts to a deployed app. A route without hosts is
+// removed. TLS asks the target to obtain certificates for the hosts.
+type Route struct {
+	Name  string
+	Hosts []string
+	App   string
+	TLS   bool
+}
+
+// Target is where deployments run
+type Target interface {
+	// Ensure prepares the target, e.g. the namespace or the reverse proxy
+	Ensure(ctx context.Context) error
+
+	// Deploy creates or updates an app and its preview route
+	Deploy(ctx context.Context, app App) error
+
+	// Status reports whether the app's current version is available. An app
+	// that cannot become available returns an error.
+	Status(ctx context.Context, name string) (bool, error)
+
+	Expose(ctx context.Context, route Route) error
+	Delete(ctx context.Context, name string) error
+}
+
+// waiter is implemented by targets that can explain a rollout timeout better
+// than polling Status
+type waiter interface {
+	Wait(ctx context.Context, name string, timeout time.Duration) error
+}
+
+// Wait blocks until the app is available, failed, or timeout has passed
+func Wait(ctx context.Context, t Target, name string, timeout time.Duration) error {
+	if w, ok := t.(waiter); ok {
+		return w.Wait(ctx, name, timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		done, err := t.Status(ctx, name)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s did not become available within %s", name, timeout)
+		case <-ticker.C:
+		}
+	}
+}
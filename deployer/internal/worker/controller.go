@@ -0,0 +1,383 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dejavu/deployer/internal/k8s"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// In controller mode the deployer does not create cluster objects while
+// handling events. It writes a DejavuApp per project from Postgres and a
+// reconcile loop converges the cluster to it.
+const (
+	ModeDirect     = "direct"
+	ModeController = "controller"
+)
+
+// How often every DejavuApp is written again from Postgres, which re-creates
+// the ones lost with a cluster restore or deleted by hand
+const appSyncInterval = 5 * time.Minute
+
+func appName(projectID string) string {
+	return fmt.Sprintf("project-%s", projectID)
+}
+
+// syncApp writes the DejavuApp of a project from the deployments in Postgres
+// that should be running: the ones being rolled out and the ready ones not
+// yet reaped. The newest deployment being rolled out becomes production once
+// it is available, until then the current production deployment keeps serving.
+func (w *Worker) syncApp(ctx context.Context, projectID string) error {
+	var slug string
+	var productionID sql.NullString
+	var projectPort int
+	var healthChecksJSON, scalingJSON []byte
+	err := w.db.QueryRow(
+		"SELECT slug, production_deployment_id, port, health_checks, scaling FROM projects WHERE id = $1",
+		projectID,
+	).Scan(&slug, &productionID, &projectPort, &healthChecksJSON, &scalingJSON)
+	if err != nil {
+		return err
+	}
+
+	var healthChecks k8s.HealthChecks
+	if err := json.Unmarshal(healthChecksJSON, &healthChecks); err != nil {
+		return fmt.Errorf("invalid health checks: %w", err)
+	}
+	var scaling k8s.Scaling
+	if err := json.Unmarshal(scalingJSON, &scaling); err != nil {
+		return fmt.Errorf("invalid scaling settings: %w", err)
+	}
+	if scaling.MinReplicas < 1 {
+		scaling.MinReplicas = 1
+	}
+
+	rows, err := w.db.Query(
+		`SELECT id, subdomain, image_url, port, status FROM deployments
+		 WHERE project_id = $1 AND status IN ('deploying', 'ready') AND image_url IS NOT NULL
+		 ORDER BY created_at`,
+		projectID,
+	)
+	if err != nil {
+		return err
+	}
+
+	spec := k8s.DejavuAppSpec{
+		ProjectID:  projectID,
+		Host:       fmt.Sprintf("%s.%s", slug, w.baseDomain),
+		Production: productionID.String,
+		CertIssuer: w.certIssuer,
+	}
+	for rows.Next() {
+		var id, subdomain, image, status string
+		var imagePort int
+		if err := rows.Scan(&id, &subdomain, &image, &imagePort, &status); err != nil {
+			rows.Close()
+			return err
+		}
+
+		d := Deployment{ImagePort: imagePort, ProjectPort: projectPort}
+		name := fmt.Sprintf("app-%s", id[:8])
+		spec.Deployments = append(spec.Deployments, k8s.AppDeployment{
+			ID:           id,
+			Name:         name,
+			Host:         fmt.Sprintf("%s.%s", subdomain, w.baseDomain),
+			Image:        image,
+			Port:         d.Port(),
+			EnvSecret:    name + "-env",
+			HealthChecks: healthChecks,
+			Scaling:      scaling,
+		})
+		if status == statusDeploying {
+			spec.Production = id
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	domainRows, err := w.db.Query(
+		"SELECT hostname FROM domains WHERE project_id = $1 AND status = 'verified' ORDER BY hostname",
+		projectID,
+	)
+	if err != nil {
+		return err
+	}
+	defer domainRows.Close()
+	for domainRows.Next() {
+		var host string
+		if err := domainRows.Scan(&host); err != nil {
+			return err
+		}
+		spec.Domains = append(spec.Domains, host)
+	}
+	if err := domainRows.Err(); err != nil {
+		return err
+	}
+
+	return w.k8sClient.ApplyApp(ctx, w.namespace, appName(projectID), spec)
+}
+
+// runController reconciles DejavuApps until the process exits
+func (w *Worker) runController() error {
+	ctx := context.Background()
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+
+	enqueue := func(name string) { queue.Add(name) }
+	if err := w.k8sClient.WatchApps(ctx, w.namespace, time.Minute, enqueue); err != nil {
+		return err
+	}
+
+	go func() {
+		for w.processNextApp(ctx, queue) {
+		}
+	}()
+	go w.syncApps(ctx)
+	return nil
+}
+
+// syncApps writes the DejavuApp of every project with deployments that should
+// be running, at startup and then every appSyncInterval
+func (w *Worker) syncApps(ctx context.Context) {
+	ticker := time.NewTicker(appSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.syncAllApps(ctx); err != nil {
+			log.Printf("Error syncing apps: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+func (w *Worker) syncAllApps(ctx context.Context) error {
+	rows, err := w.db.Query(
+		`SELECT DISTINCT project_id FROM deployments
+		 WHERE status IN ('deploying', 'ready') AND image_url IS NOT NULL`,
+	)
+	if err != nil {
+		return err
+	}
+	var projectIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		projectIDs = append(projectIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range projectIDs {
+		if err := w.syncApp(ctx, id); err != nil {
+			log.Printf("Error syncing app of project %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func (w *Worker) processNextApp(ctx context.Context, queue workqueue.RateLimitingInterface) bool {
+	item, shutdown := queue.Get()
+	if shutdown {
+		return false
+	}
+	defer queue.Done(item)
+
+	name := item.(string)
+	progressing, err := w.reconcileApp(ctx, name)
+	if err != nil {
+		log.Printf("Error reconciling %s: %v", name, err)
+		queue.AddRateLimited(item)
+		return true
+	}
+	queue.Forget(item)
+
+	// Pod failures do not always show up on the Deployment, so rollouts in
+	// progress are checked again after a while
+	if progressing {
+		queue.AddAfter(item, 5*time.Second)
+	}
+	return true
+}
+
+// reconcileApp converges the cluster to a DejavuApp and records the outcome
+// of rollouts in Postgres. It reports whether a rollout is still in progress.
+func (w *Worker) reconcileApp(ctx context.Context, name string) (bool, error) {
+	app, err := w.k8sClient.GetApp(ctx, w.namespace, name)
+	if err != nil || app == nil {
+		return false, err
+	}
+	owner := app.Owner()
+
+	if err := w.k8sClient.EnsureNamespace(ctx, w.namespace); err != nil {
+		return false, err
+	}
+
+	// 1. Apply the objects of every deployment and check their rollout
+	wanted := map[string]bool{}
+	available := map[string]bool{}
+	var failed []string
+	progressing := false
+	for _, d := range app.Spec.Deployments {
+		wanted[d.Name] = true
+
+		if d.EnvSecret != "" {
+			if err := w.ensureEnvSecret(ctx, app.Spec.ProjectID, d.EnvSecret, owner); err != nil {
+				return false, fmt.Errorf("applying env secret %s: %w", d.EnvSecret, err)
+			}
+		}
+
+		spec := k8s.AppSpec{
+			Image:            d.Image,
+			Port:             d.Port,
+			EnvSecret:        d.EnvSecret,
+			HealthChecks:     d.HealthChecks,
+			Scaling:          d.Scaling,
+			ProgressDeadline: w.rolloutTimeout,
+			Owner:            owner,
+		}
+		deploymentOwner, err := w.k8sClient.ApplyDeployment(ctx, w.namespace, d.Name, spec)
+		if err != nil {
+			return false, fmt.Errorf("applying deployment %s: %w", d.Name, err)
+		}
+		if err := w.k8sClient.ApplyService(ctx, w.namespace, d.Name, 80, d.Port, deploymentOwner); err != nil {
+			return false, fmt.Errorf("applying service %s: %w", d.Name, err)
+		}
+		if err := w.k8sClient.ApplyIngress(ctx, w.namespace, d.Name, d.Host, d.Name, 80, deploymentOwner); err != nil {
+			return false, fmt.Errorf("applying ingress %s: %w", d.Name, err)
+		}
+		if err := w.k8sClient.ApplyHPA(ctx, w.namespace, d.Name, d.Scaling, deploymentOwner); err != nil {
+			log.Printf("Error applying HPA: %v", err)
+			// HPA is optional, continue anyway
+		}
+
+		done, err := w.k8sClient.RolloutStatus(ctx, w.namespace, d.Name)
+		switch {
+		case err != nil:
+			if _, ok := err.(*k8s.RolloutError); !ok {
+				return false, err
+			}
+			if w.deploymentStatus(d.ID) == statusDeploying {
+				log.Printf("Rollout of %s failed: %v", d.ID, err)
+				w.failDeployment(d.ID, err.Error())
+				failed = append(failed, d.ID)
+			}
+		case done:
+			available[d.ID] = true
+		default:
+			progressing = true
+		}
+	}
+
+	// 2. Remove deployments that were dropped from the spec
+	names, err := w.k8sClient.AppDeployments(ctx, w.namespace, app)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range names {
+		if wanted[name] {
+			continue
+		}
+		if err := w.k8sClient.DeleteDeployment(ctx, w.namespace, name); err != nil {
+			return false, err
+		}
+		log.Printf("🧹 Removed %s from %s", name, app.Name)
+	}
+
+	// 3. Route production to the wanted deployment once it is available; the
+	// previous one keeps serving until then
+	serving := app.Status.Serving
+	if available[app.Spec.Production] && app.Spec.Production != serving {
+		// Only a deployment that is still deploying may take over, not one
+		// the API failed meanwhile
+		if w.markReady(app.Spec.Production) {
+			serving = app.Spec.Production
+		} else {
+			failed = append(failed, app.Spec.Production)
+		}
+	}
+	if serving != "" && available[serving] {
+		serviceName := fmt.Sprintf("app-%s", serving[:8])
+		aliasName := fmt.Sprintf("prod-%s", app.Spec.ProjectID[:8])
+		if err := w.k8sClient.ApplyIngress(ctx, w.namespace, aliasName, app.Spec.Host, serviceName, 80, owner); err != nil {
+			return false, fmt.Errorf("applying production alias: %w", err)
+		}
+
+		domainIngress := domainIngressName(app.Spec.ProjectID)
+		if err := w.k8sClient.ApplyDomainIngress(ctx, w.namespace, domainIngress, app.Spec.Domains, serviceName, 80, app.Spec.CertIssuer, owner); err != nil {
+			log.Printf("Error syncing custom domains: %v", err)
+		} else if _, err := w.db.Exec(
+			"UPDATE domains SET tls_status = 'provisioning' WHERE project_id = $1 AND status = 'verified' AND tls_status = 'none'",
+			app.Spec.ProjectID,
+		); err != nil {
+			log.Printf("Error updating domain TLS status: %v", err)
+		}
+	}
+
+	// 4. Write the outcome back to Postgres
+	for id := range available {
+		if w.deploymentStatus(id) == statusDeploying {
+			w.transition(id, statusReady, "")
+			log.Printf("✅ Deployment %s is ready", id)
+		}
+	}
+	if serving != app.Status.Serving {
+		w.setProductionDeployment(app.Spec.ProjectID, serving)
+		log.Printf("🔀 %s now serves %s", app.Spec.Host, serving)
+		w.reap(ctx, app.Spec.ProjectID)
+	}
+
+	if serving != app.Status.Serving || app.Status.ObservedGeneration != app.Generation {
+		status := k8s.DejavuAppStatus{Serving: serving, ObservedGeneration: app.Generation}
+		if err := w.k8sClient.UpdateAppStatus(ctx, w.namespace, app.Name, status); err != nil {
+			return false, err
+		}
+	}
+
+	// Failed and reaped deployments leave the spec
+	if len(failed) > 0 || serving != app.Status.Serving {
+		if err := w.syncApp(ctx, app.Spec.ProjectID); err != nil {
+			return false, err
+		}
+	}
+
+	return progressing, nil
+}
+
+// ensureEnvSecret makes the env secret of a deployment part of its app. A
+// secret lost with the cluster is written again from the project's runtime
+// env vars.
+func (w *Worker) ensureEnvSecret(ctx context.Context, projectID, name string, owner *k8s.Owner) error {
+	found, err := w.k8sClient.OwnSecret(ctx, w.namespace, name, owner)
+	if err != nil || found {
+		return err
+	}
+
+	env, err := w.getRuntimeEnv(projectID)
+	if err != nil {
+		return err
+	}
+	if err := w.k8sClient.ApplySecret(ctx, w.namespace, name, env); err != nil {
+		return err
+	}
+	_, err = w.k8sClient.OwnSecret(ctx, w.namespace, name, owner)
+	return err
+}
+
+func (w *Worker) deploymentStatus(id string) string {
+	var status string
+	if err := w.db.QueryRow("SELECT status FROM deployments WHERE id = $1", id).Scan(&status); err != nil {
+		log.Printf("Error getting deployment status: %v", err)
+	}
+	return status
+}
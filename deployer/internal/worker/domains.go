@@ -0,0 +1,158 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dejavu/deployer/internal/target"
+)
+
+// DomainsChangedEvent is published by the API when a custom domain is
+// verified or removed
+type DomainsChangedEvent struct {
+	ProjectID string `json:"project_id"`
+}
+
+func domainIngressName(projectID string) string {
+	return fmt.Sprintf("domains-%s", projectID[:8])
+}
+
+// processDomainsChanged re-points a project's custom domains at its current
+// production deployment. Failures are retried since the sync is idempotent.
+func (w *Worker) processDomainsChanged(event DomainsChangedEvent) error {
+	if w.mode == ModeController {
+		if err := w.syncApp(context.Background(), event.ProjectID); err != nil {
+			log.Printf("Error updating app: %v", err)
+			return retry(err)
+		}
+		return nil
+	}
+
+	var productionID sql.NullString
+	err := w.db.QueryRow(
+		"SELECT production_deployment_id FROM projects WHERE id = $1",
+		event.ProjectID,
+	).Scan(&productionID)
+	if err == sql.ErrNoRows {
+		// The project was deleted meanwhile
+		return nil
+	}
+	if err != nil {
+		log.Printf("Error getting production deployment: %v", err)
+		return retry(err)
+	}
+
+	// Without a production deployment the domains are routed on first rollout
+	if !productionID.Valid {
+		return nil
+	}
+
+	app := fmt.Sprintf("app-%s", productionID.String[:8])
+	if err := w.syncDomains(context.Background(), event.ProjectID, app); err != nil {
+		log.Printf("Error syncing custom domains: %v", err)
+		return retry(err)
+	}
+	return nil
+}
+
+// syncDomains routes every verified custom domain of a project to app
+func (w *Worker) syncDomains(ctx context.Context, projectID, app string) error {
+	rows, err := w.db.Query(
+		"SELECT hostname FROM domains WHERE project_id = $1 AND status = 'verified' ORDER BY hostname",
+		projectID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var hosts []string
+	for rows.Next() {
+		var host string
+		if err := rows.Scan(&host); err != nil {
+			return err
+		}
+		hosts = append(hosts, host)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	route := target.Route{Name: domainIngressName(projectID), Hosts: hosts, App: app, TLS: true}
+	if err := w.target.Expose(ctx, route); err != nil {
+		return err
+	}
+
+	// Only the cluster issues certificates
+	if w.k8sClient == nil {
+		return nil
+	}
+
+	_, err = w.db.Exec(
+		"UPDATE domains SET tls_status = 'provisioning' WHERE project_id = $1 AND status = 'verified' AND tls_status = 'none'",
+		projectID,
+	)
+	return err
+}
+
+// watchCertificates marks domains as TLS active once cert-manager has issued
+// a certificate covering them
+func (w *Worker) watchCertificates() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := w.checkCertificates(context.Background()); err != nil {
+			log.Printf("Error checking certificates: %v", err)
+		}
+	}
+}
+
+func (w *Worker) checkCertificates(ctx context.Context) error {
+	rows, err := w.db.Query(
+		"SELECT id, project_id, hostname FROM domains WHERE status = 'verified' AND tls_status = 'provisioning'",
+	)
+	if err != nil {
+		return err
+	}
+
+	type pendingDomain struct {
+		id, projectID, hostname string
+	}
+	var pending []pendingDomain
+	for rows.Next() {
+		var d pendingDomain
+		if err := rows.Scan(&d.id, &d.projectID, &d.hostname); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, d)
+	}
+	rows.Close()
+
+	issued := map[string][]string{}
+	for _, d := range pending {
+		hosts, ok := issued[d.projectID]
+		if !ok {
+			hosts, err = w.k8sClient.CertificateHosts(ctx, w.namespace, domainIngressName(d.projectID)+"-tls")
+			if err != nil {
+				return err
+			}
+			issued[d.projectID] = hosts
+		}
+
+		for _, host := range hosts {
+			if host == d.hostname {
+				if _, err := w.db.Exec("UPDATE domains SET tls_status = 'active' WHERE id = $1", d.id); err != nil {
+					return err
+				}
+				log.Printf("🔒 Certificate issued for %s", d.hostname)
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/dejavu/deployer/internal/target"
+)
+
+// ProjectTeardownEvent is published by the API after a project is deleted
+type ProjectTeardownEvent struct {
+	ProjectID     string   `json:"project_id"`
+	DeploymentIDs []string `json:"deployment_ids"`
+}
+
+// reap deletes the cluster objects of ready deployments beyond the
+// keepDeployments most recent ones. The production deployment is always kept.
+// Reaped deployments are marked archived so they can still be rolled back to.
+func (w *Worker) reap(ctx context.Context, projectID string) {
+	rows, err := w.db.Query(
+		`SELECT d.id
+		 FROM deployments d JOIN projects p ON p.id = d.project_id
+		 WHERE d.project_id = $1
+		   AND d.status = 'ready'
+		   AND d.id IS DISTINCT FROM p.production_deployment_id
+		 ORDER BY d.created_at DESC
+		 OFFSET $2`,
+		projectID, w.keepDeployments,
+	)
+	if err != nil {
+		log.Printf("Error listing superseded deployments: %v", err)
+		return
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("Error listing superseded deployments: %v", err)
+			rows.Close()
+			return
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		// In controller mode the objects go once the app is synced
+		if w.mode == ModeController {
+			w.transition(id, statusArchived, "")
+			continue
+		}
+		if err := w.target.Delete(ctx, fmt.Sprintf("app-%s", id[:8])); err != nil {
+			log.Printf("Error reaping deployment %s: %v", id, err)
+			continue
+		}
+		w.transition(id, statusArchived, "")
+		log.Printf("🧹 Reaped deployment %s", id)
+	}
+}
+
+// processTeardown removes every cluster object created for a deleted project.
+// It returns a retry error when anything was left behind; deleting again is
+// harmless.
+func (w *Worker) processTeardown(event ProjectTeardownEvent) error {
+	ctx := context.Background()
+	var failed error
+
+	if w.mode == ModeController {
+		if err := w.k8sClient.DeleteApp(ctx, w.namespace, appName(event.ProjectID)); err != nil {
+			log.Printf("Error deleting app: %v", err)
+			failed = err
+		}
+	}
+
+	for _, id := range event.DeploymentIDs {
+		if err := w.target.Delete(ctx, fmt.Sprintf("app-%s", id[:8])); err != nil {
+			log.Printf("Error deleting deployment %s: %v", id, err)
+			failed = err
+		}
+	}
+
+	alias := target.Route{Name: fmt.Sprintf("prod-%s", event.ProjectID[:8])}
+	if err := w.target.Expose(ctx, alias); err != nil {
+		log.Printf("Error deleting production alias: %v", err)
+		failed = err
+	}
+
+	domains := target.Route{Name: domainIngressName(event.ProjectID), TLS: true}
+	if err := w.target.Expose(ctx, domains); err != nil {
+		log.Printf("Error deleting custom domain routes: %v", err)
+		failed = err
+	}
+
+	if failed != nil {
+		return retry(failed)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package worker
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	statusPending   = "pending"
+	statusQueued    = "queued"
+	statusBuilding  = "building"
+	statusDeploying = "deploying"
+	statusReady     = "ready"
+	statusError     = "error"
+	statusCancelled = "cancelled"
+	statusTimedOut  = "timed_out"
+	statusArchived  = "archived"
+)
+
+// transition moves a deployment to status, recording the move in
+// deployment_events and announcing it on DEPLOYMENTS.status. It reports false,
+// changing nothing, when the current status does not allow the move, e.g. for
+// a deployment cancelled while its build finished.
+func (w *Worker) transition(id, status, reason string) bool {
+	from, moved, err := w.moveDeployment(id, status, reason)
+	if err != nil {
+		log.Printf("Error updating deployment status: %v", err)
+		return false
+	}
+	if !moved {
+		if from != status {
+			log.Printf("Deployment %s cannot move from %q to %q", id, from, status)
+		}
+		return false
+	}
+
+	w.announce(id, status)
+	return true
+}
+
+// startRollout moves a deployment to deploying. It also reports true for a
+// deployment that is deploying already, whose rollout a redelivered message
+// retries.
+func (w *Worker) startRollout(id string) (bool, error) {
+	from, moved, err := w.moveDeployment(id, statusDeploying, "")
+	if err != nil {
+		return false, err
+	}
+	if moved {
+		w.announce(id, statusDeploying)
+	} else if from != statusDeploying {
+		log.Printf("Deployment %s cannot move from %q to %q", id, from, statusDeploying)
+		return false, nil
+	}
+
+	// The API fails rollouts still deploying after this deadline, by which
+	// every delivery of the message has run out
+	deadline := maxDeliver * (w.rolloutTimeout + defaultAckWait + retryDelay)
+	_, err = w.db.Exec(
+		`UPDATE deployments SET rollout_deadline = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second'
+		 WHERE id = $1 AND rollout_deadline IS NULL`,
+		id, int(deadline.Seconds()),
+	)
+	return true, err
+}
+
+// markReady moves a deployment whose rollout finished to ready. It reports
+// false for a deployment that is neither deploying nor ready, e.g. one the
+// API failed meanwhile, which must not become production.
+func (w *Worker) markReady(id string) bool {
+	switch w.deploymentStatus(id) {
+	case statusReady:
+		return true
+	case statusDeploying:
+		return w.transition(id, statusReady, "")
+	}
+	return false
+}
+
+// announce publishes a status change on DEPLOYMENTS.status, so the API
+// delivers lifecycle webhooks
+func (w *Worker) announce(id, status string) {
+	data, _ := json.Marshal(StatusEvent{
+		DeploymentID: id,
+		Status:       status,
+		Timestamp:    time.Now(),
+	})
+	if _, err := w.js.Publish("DEPLOYMENTS.status", data); err != nil {
+		log.Printf("Error publishing status event: %v", err)
+	}
+}
+
+// failDeployment records why a deployment failed and marks it as error
+func (w *Worker) failDeployment(id, reason string) {
+	w.transition(id, statusError, reason)
+}
+
+// moveDeployment applies a transition in one database transaction. Reasons of
+// failures also become the deployment's error message.
+func (w *Worker) moveDeployment(id, status, reason string) (string, bool, error) {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	var from string
+	err = tx.QueryRow("SELECT status FROM deployments WHERE id = $1 FOR UPDATE", id).Scan(&from)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	// The backend's migrations store its state machine in
+	// deployment_transitions
+	var allowed bool
+	err = tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM deployment_transitions WHERE from_status = $1 AND to_status = $2)",
+		from, status,
+	).Scan(&allowed)
+	if err != nil {
+		return "", false, err
+	}
+	if !allowed {
+		return from, false, nil
+	}
+
+	if status == statusError || status == statusTimedOut {
+		_, err = tx.Exec(
+			"UPDATE deployments SET status = $1, error_message = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3",
+			status, reason, id,
+		)
+	} else {
+		_, err = tx.Exec(
+			"UPDATE deployments SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+			status, id,
+		)
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO deployment_events (deployment_id, from_status, to_status, reason) VALUES ($1, $2, $3, $4)",
+		id, from, status, reason,
+	)
+	if err != nil {
+		return "", false, err
+	}
+	return from, true, tx.Commit()
+}
@@ -7,8 +7,12 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/dejavu/deployer/internal/k8s"
+	"github.com/dejavu/deployer/internal/secret"
+	"github.com/dejavu/deployer/internal/target"
 	_ "github.com/lib/pq"
 	"github.com/nats-io/nats.go"
 )
@@ -16,17 +20,64 @@ import (
 type Worker struct {
 	nats       *nats.Conn
 	js         nats.JetStreamContext
-	k8sClient  *k8s.Client
+	target     target.Target
+	k8sClient  *k8s.Client // nil unless deploying to Kubernetes
 	db         *sql.DB
+	cipher     *secret.Cipher
 	namespace  string
 	baseDomain string
+	certIssuer string
+	mode       string // ModeDirect or ModeController
+
+	// ready deployments per project whose cluster objects are kept
+	keepDeployments int
+	rolloutTimeout  time.Duration
+}
+
+const (
+	// A deployer that dies mid-rollout leaves its message to another one
+	// after the ack wait
+	defaultAckWait = time.Minute
+
+	// Attempts per message before JetStream gives up on it, e.g. while the
+	// cluster or Postgres is unreachable
+	maxDeliver = 5
+	retryDelay = 10 * time.Second
+)
+
+// BuildStartedEvent is published by the builder that picked up a build
+type BuildStartedEvent struct {
+	DeploymentID string    `json:"deployment_id"`
+	Attempt      int       `json:"attempt"`
+	Timestamp    time.Time `json:"timestamp"`
 }
 
 type BuildCompleteEvent struct {
 	DeploymentID string `json:"deployment_id"`
 	ImageURL     string `json:"image_url"`
 	Success      bool   `json:"success"`
-	Logs         string `json:"logs"`
+	Port         int    `json:"port,omitempty"`
+	Reason       string `json:"reason,omitempty"` // cancelled or timed_out when the build did not succeed
+	Error        string `json:"error,omitempty"`
+
+	CommitHash    string `json:"commit_hash,omitempty"`
+	CommitAuthor  string `json:"commit_author,omitempty"`
+	CommitMessage string `json:"commit_message,omitempty"`
+}
+
+// PromoteEvent asks to roll out an already built image, e.g. for a rollback
+type PromoteEvent struct {
+	DeploymentID       string `json:"deployment_id"`
+	ProjectID          string `json:"project_id"`
+	SourceDeploymentID string `json:"source_deployment_id"`
+	ImageURL           string `json:"image_url"`
+}
+
+// StatusEvent is published on DEPLOYMENTS.status for every status change
+type StatusEvent struct {
+	DeploymentID string    `json:"deployment_id"`
+	Status       string    `json:"status"`
+	Timestamp    time.Time `json:"timestamp"`
 }
 
 func New() (*Worker, error) {
@@ -46,14 +97,18 @@ func New() (*Worker, error) {
 		return nil, err
 	}
 
-	// Connect to Kubernetes
-	k8sClient, err := k8s.NewClient()
+	// Connect to database
+	db, err := connectDB()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create k8s client: %w", err)
+		return nil, err
 	}
 
-	// Connect to database
-	db, err := connectDB()
+	encryptionKey := os.Getenv("ENCRYPTION_KEY")
+	if encryptionKey == "" {
+		return nil, fmt.Errorf("ENCRYPTION_KEY is not set")
+	}
+
+	cipher, err := secret.NewCipher(encryptionKey)
 	if err != nil {
 		return nil, err
 	}
@@ -68,138 +123,462 @@ func New() (*Worker, error) {
 		baseDomain = "dejavu.local"
 	}
 
+	certIssuer := os.Getenv("CERT_ISSUER")
+	if certIssuer == "" {
+		certIssuer = "letsencrypt-prod"
+	}
+
+	keepDeployments, err := strconv.Atoi(os.Getenv("KEEP_DEPLOYMENTS"))
+	if err != nil || keepDeployments <= 0 {
+		keepDeployments = 3
+	}
+
+	rolloutTimeout, err := time.ParseDuration(os.Getenv("ROLLOUT_TIMEOUT"))
+	if err != nil || rolloutTimeout <= 0 {
+		rolloutTimeout = 5 * time.Minute
+	}
+
+	mode := os.Getenv("DEPLOYER_MODE")
+	switch mode {
+	case "":
+		mode = ModeDirect
+	case ModeDirect, ModeController:
+	default:
+		return nil, fmt.Errorf("unknown DEPLOYER_MODE %q", mode)
+	}
+
+	// Choose where deployments run
+	var deployTarget target.Target
+	var k8sClient *k8s.Client
+	switch os.Getenv("DEPLOY_TARGET") {
+	case "", "kubernetes":
+		k8sClient, err = k8s.NewClient()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create k8s client: %w", err)
+		}
+		deployTarget = target.NewKubernetes(k8sClient, namespace, certIssuer)
+	case "docker":
+		if mode == ModeController {
+			return nil, fmt.Errorf("controller mode requires the kubernetes target")
+		}
+
+		proxyAddr := os.Getenv("DOCKER_PROXY_ADDR")
+		if proxyAddr == "" {
+			proxyAddr = "127.0.0.1:8888"
+		}
+		routesFile := os.Getenv("DOCKER_ROUTES_FILE")
+		if routesFile == "" {
+			routesFile = "routes.json"
+		}
+
+		deployTarget, err = target.NewDocker(proxyAddr, routesFile)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown DEPLOY_TARGET %q", os.Getenv("DEPLOY_TARGET"))
+	}
+
 	return &Worker{
 		nats:       nc,
 		js:         js,
+		target:     deployTarget,
 		k8sClient:  k8sClient,
 		db:         db,
+		cipher:     cipher,
 		namespace:  namespace,
 		baseDomain: baseDomain,
+		certIssuer: certIssuer,
+		mode:       mode,
+
+		keepDeployments: keepDeployments,
+		rolloutTimeout:  rolloutTimeout,
 	}, nil
 }
 
 func (w *Worker) Start() error {
-	_, err := w.js.Subscribe("BUILDS.complete", func(msg *nats.Msg) {
-		var event BuildCompleteEvent
+	// Rollouts wait for the new version for up to the rollout timeout. Their
+	// messages must not be redelivered meanwhile, even if a heartbeat is lost,
+	// or the rollout would be applied a second time.
+	rolloutAckWait := w.rolloutTimeout + defaultAckWait
+
+	// Durable consumers shared by all deployers, so each event is handled once
+	// BUILDS.* takes BUILDS.started and BUILDS.complete, but not the logs, on
+	// one consumer so a build's events are handled in order
+	err := consume(w.js, "BUILDS.*", "deployer-builds", 1, rolloutAckWait, func(msg *nats.Msg) error {
+		switch msg.Subject {
+		case "BUILDS.started":
+			var event BuildStartedEvent
+			if err := json.Unmarshal(msg.Data, &event); err != nil {
+				return fmt.Errorf("invalid build started event: %w", err)
+			}
+
+			log.Printf("🔨 Building: %s (attempt %d)", event.DeploymentID, event.Attempt)
+			w.transition(event.DeploymentID, statusBuilding, "")
+		case "BUILDS.complete":
+			var event BuildCompleteEvent
+			if err := json.Unmarshal(msg.Data, &event); err != nil {
+				return fmt.Errorf("invalid build complete event: %w", err)
+			}
+
+			log.Printf("🚀 Deploying: %s", event.DeploymentID)
+			if err := w.processDeploy(event); err != nil {
+				return w.retryRollout(msg, event.DeploymentID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = consume(w.js, "DEPLOYMENTS.promote", "deployer-promote", 1, rolloutAckWait, func(msg *nats.Msg) error {
+		var event PromoteEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			return fmt.Errorf("invalid promote event: %w", err)
+		}
+
+		log.Printf("⏪ Promoting %s from %s", event.DeploymentID, event.SourceDeploymentID)
+		if err := w.processPromote(event); err != nil {
+			return w.retryRollout(msg, event.DeploymentID, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = consume(w.js, "DEPLOYMENTS.domains", "deployer-domains", 1, defaultAckWait, func(msg *nats.Msg) error {
+		var event DomainsChangedEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			return fmt.Errorf("invalid domains event: %w", err)
+		}
+
+		log.Printf("🌐 Syncing custom domains of project %s", event.ProjectID)
+		return w.processDomainsChanged(event)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = consume(w.js, "DEPLOYMENTS.teardown", "deployer-teardown", 1, defaultAckWait, func(msg *nats.Msg) error {
+		var event ProjectTeardownEvent
 		if err := json.Unmarshal(msg.Data, &event); err != nil {
-			log.Printf("Error parsing event: %v", err)
-			msg.Ack()
-			return
+			return fmt.Errorf("invalid teardown event: %w", err)
 		}
 
-		log.Printf("🚀 Deploying: %s", event.DeploymentID)
-		w.processDeploy(event)
-		msg.Ack()
+		log.Printf("🗑️  Tearing down project %s", event.ProjectID)
+		return w.processTeardown(event)
 	})
+	if err != nil {
+		return err
+	}
+
+	// Bring up the target, e.g. the Docker proxy, before the first deployment
+	if err := w.target.Ensure(context.Background()); err != nil {
+		return err
+	}
+
+	// Certificates are only issued inside the cluster
+	if w.k8sClient != nil {
+		go w.watchCertificates()
+	}
+
+	if w.mode == ModeController {
+		log.Println("🎛️  Running in controller mode")
+		return w.runController()
+	}
 
-	return err
+	return nil
 }
 
-func (w *Worker) processDeploy(event BuildCompleteEvent) {
-	ctx := context.Background()
+// retryRollout has a rollout that failed on a transient error, e.g. an
+// unreachable cluster, retried until its last delivery fails the deployment
+func (w *Worker) retryRollout(msg *nats.Msg, deploymentID string, err error) error {
+	if attempt(msg) < maxDeliver {
+		return retry(err)
+	}
+	log.Printf("Giving up on deployment %s: %v", deploymentID, err)
+	w.failDeployment(deploymentID, err.Error())
+	return nil
+}
 
-	// Update status to deploying
-	w.updateDeploymentStatus(event.DeploymentID, "deploying")
-	w.updateDeploymentLogs(event.DeploymentID, event.Logs)
+// processDeploy rolls out a finished build. Its error is transient and worth
+// a retry; everything else fails the deployment.
+func (w *Worker) processDeploy(event BuildCompleteEvent) error {
+	// Record what was actually built, also for failed builds
+	if event.CommitHash != "" {
+		w.updateDeploymentCommit(event.DeploymentID, event.CommitHash, event.CommitAuthor, event.CommitMessage)
+	}
 
 	if !event.Success {
-		w.updateDeploymentStatus(event.DeploymentID, "error")
-		return
+		switch event.Reason {
+		case "cancelled":
+			// The API marked it cancelled already
+		case "timed_out":
+			w.transition(event.DeploymentID, statusTimedOut, event.Error)
+		default:
+			w.failDeployment(event.DeploymentID, "build failed")
+		}
+		return nil
+	}
+
+	// Update status to deploying, unless it was cancelled as the build finished
+	deploying, err := w.startRollout(event.DeploymentID)
+	if err != nil {
+		return fmt.Errorf("error updating deployment status: %w", err)
+	}
+	if !deploying {
+		log.Printf("Skipping deployment %s", event.DeploymentID)
+		return nil
+	}
+
+	// Update image URL and the port the image listens on
+	w.updateDeploymentImage(event.DeploymentID, event.ImageURL, event.Port)
+
+	return w.rollout(event.DeploymentID, event.ImageURL)
+}
+
+// processPromote rolls out the image of an earlier deployment without a build
+func (w *Worker) processPromote(event PromoteEvent) error {
+	deploying, err := w.startRollout(event.DeploymentID)
+	if err != nil {
+		return fmt.Errorf("error updating deployment status: %w", err)
+	}
+	if !deploying {
+		log.Printf("Skipping deployment %s", event.DeploymentID)
+		return nil
 	}
 
-	// Update image URL
-	w.updateDeploymentImage(event.DeploymentID, event.ImageURL)
+	return w.rollout(event.DeploymentID, event.ImageURL)
+}
+
+// rollout runs a deployment on the target and, once it is available, makes it
+// the production deployment of its project. It returns the errors of the
+// cluster, the target and Postgres, which are worth a retry; a new version
+// that does not become available fails the deployment instead.
+func (w *Worker) rollout(deploymentID, imageURL string) error {
+	ctx := context.Background()
 
 	// Get deployment info
-	deployment, err := w.getDeployment(event.DeploymentID)
+	deployment, err := w.getDeployment(deploymentID)
 	if err != nil {
-		log.Printf("Error getting deployment: %v", err)
-		w.updateDeploymentStatus(event.DeploymentID, "error")
-		return
+		return fmt.Errorf("error getting deployment: %w", err)
 	}
 
-	// 1. Create namespace if not exists
-	if err := w.k8sClient.EnsureNamespace(ctx, w.namespace); err != nil {
-		log.Printf("Error creating namespace: %v", err)
-		w.updateDeploymentStatus(event.DeploymentID, "error")
-		return
+	// 1. Prepare the target
+	if err := w.target.Ensure(ctx); err != nil {
+		return fmt.Errorf("error preparing target: %w", err)
 	}
 
-	// 2. Create deployment
-	deploymentName := fmt.Sprintf("app-%s", event.DeploymentID[:8])
-	if err := w.k8sClient.CreateDeployment(ctx, w.namespace, deploymentName, event.ImageURL, nil); err != nil {
-		log.Printf("Error creating deployment: %v", err)
-		w.updateDeploymentStatus(event.DeploymentID, "error")
-		return
+	// 2. Load runtime env vars
+	deploymentName := fmt.Sprintf("app-%s", deploymentID[:8])
+	env, err := w.getRuntimeEnv(deployment.ProjectID)
+	if err != nil {
+		return fmt.Errorf("error loading env vars: %w", err)
 	}
 
-	// 3. Create service
-	if err := w.k8sClient.CreateService(ctx, w.namespace, deploymentName, 80); err != nil {
-		log.Printf("Error creating service: %v", err)
-		w.updateDeploymentStatus(event.DeploymentID, "error")
-		return
+	// In controller mode the reconcile loop takes it from here. The env
+	// secret always exists as the DejavuApp refers to it.
+	if w.mode == ModeController {
+		if err := w.k8sClient.ApplySecret(ctx, w.namespace, deploymentName+"-env", env); err != nil {
+			return fmt.Errorf("error creating env secret: %w", err)
+		}
+		if err := w.syncApp(ctx, deployment.ProjectID); err != nil {
+			return fmt.Errorf("error updating app: %w", err)
+		}
+		return nil
 	}
 
-	// 4. Create ingress
+	// 3. Run the app with its preview route
 	host := fmt.Sprintf("%s.%s", deployment.Subdomain, w.baseDomain)
-	if err := w.k8sClient.CreateIngress(ctx, w.namespace, deploymentName, host, deploymentName, 80); err != nil {
-		log.Printf("Error creating ingress: %v", err)
-		w.updateDeploymentStatus(event.DeploymentID, "error")
-		return
+	app := target.App{
+		Name:         deploymentName,
+		Image:        imageURL,
+		Host:         host,
+		Port:         deployment.Port(),
+		Env:          env,
+		HealthChecks: deployment.HealthChecks,
+		Scaling:      deployment.Scaling,
+	}
+	if err := w.target.Deploy(ctx, app); err != nil {
+		return fmt.Errorf("error deploying app: %w", err)
+	}
+
+	// 4. Wait until the new version is available. The production alias has
+	// not moved yet, so on failure the previous version keeps serving.
+	if err := target.Wait(ctx, w.target, deploymentName, w.rolloutTimeout); err != nil {
+		log.Printf("Rollout of %s failed: %v", deploymentID, err)
+		w.failDeployment(deploymentID, err.Error())
+		if err := w.target.Delete(ctx, deploymentName); err != nil {
+			log.Printf("Error cleaning up failed deployment: %v", err)
+		}
+		return nil
+	}
+
+	// 5. Move the project's production alias to this deployment
+	productionHost := fmt.Sprintf("%s.%s", deployment.ProjectSlug, w.baseDomain)
+	alias := target.Route{
+		Name:  fmt.Sprintf("prod-%s", deployment.ProjectID[:8]),
+		Hosts: []string{productionHost},
+		App:   deploymentName,
+	}
+	if err := w.target.Expose(ctx, alias); err != nil {
+		return fmt.Errorf("error updating production alias: %w", err)
+	}
+
+	// 6. Route verified custom domains to this deployment
+	if err := w.syncDomains(ctx, deployment.ProjectID, deploymentName); err != nil {
+		log.Printf("Error syncing custom domains: %v", err)
+		// Custom domains are retried on the next change, continue anyway
+	}
+
+	// Update status to ready. A deployment that is no longer deploying, e.g.
+	// one the API failed as stuck meanwhile, gives production back.
+	if !w.markReady(deploymentID) {
+		log.Printf("Deployment %s is no longer deploying, restoring production", deploymentID)
+		if err := w.restoreProduction(ctx, deployment.ProjectID, alias); err != nil {
+			log.Printf("Error restoring production alias: %v", err)
+		}
+		if err := w.target.Delete(ctx, deploymentName); err != nil {
+			log.Printf("Error cleaning up deployment: %v", err)
+		}
+		return nil
+	}
+	w.setProductionDeployment(deployment.ProjectID, deploymentID)
+	log.Printf("✅ Deployment %s is ready at %s (production: %s)", deploymentID, host, productionHost)
+
+	// Free the cluster resources of superseded deployments
+	w.reap(ctx, deployment.ProjectID)
+	return nil
+}
+
+// restoreProduction routes the production alias and custom domains of a
+// project back to its production deployment, or removes them without one
+func (w *Worker) restoreProduction(ctx context.Context, projectID string, alias target.Route) error {
+	var productionID sql.NullString
+	err := w.db.QueryRow("SELECT production_deployment_id FROM projects WHERE id = $1", projectID).Scan(&productionID)
+	if err != nil {
+		return err
 	}
 
-	// 5. Create HPA
-	if err := w.k8sClient.CreateHPA(ctx, w.namespace, deploymentName, 2, 10); err != nil {
-		log.Printf("Error creating HPA: %v", err)
-		// HPA is optional, continue anyway
+	if !productionID.Valid {
+		alias.Hosts = nil
+		if err := w.target.Expose(ctx, alias); err != nil {
+			return err
+		}
+		return w.target.Expose(ctx, target.Route{Name: domainIngressName(projectID)})
 	}
 
-	// Update status to ready
-	w.updateDeploymentStatus(event.DeploymentID, "ready")
-	log.Printf("✅ Deployment %s is ready at %s", event.DeploymentID, host)
+	alias.App = fmt.Sprintf("app-%s", productionID.String[:8])
+	if err := w.target.Expose(ctx, alias); err != nil {
+		return err
+	}
+	return w.syncDomains(ctx, projectID, alias.App)
 }
 
 type Deployment struct {
-	ID        string
-	Subdomain string
+	ID           string
+	ProjectID    string
+	ProjectSlug  string
+	Subdomain    string
+	ImagePort    int // detected by the builder
+	ProjectPort  int // configured on the project, 0 if not set
+	HealthChecks k8s.HealthChecks
+	Scaling      k8s.Scaling
+}
+
+// Port is the container port: the project override, else the port the image
+// was built for, else 80
+func (d *Deployment) Port() int32 {
+	switch {
+	case d.ProjectPort > 0:
+		return int32(d.ProjectPort)
+	case d.ImagePort > 0:
+		return int32(d.ImagePort)
+	default:
+		return 80
+	}
 }
 
 func (w *Worker) getDeployment(id string) (*Deployment, error) {
 	var d Deployment
+	var healthChecks, scaling []byte
 	err := w.db.QueryRow(
-		"SELECT id, subdomain FROM deployments WHERE id = $1",
+		`SELECT d.id, d.project_id, p.slug, d.subdomain, d.port, p.port, p.health_checks, p.scaling
+		 FROM deployments d JOIN projects p ON p.id = d.project_id
+		 WHERE d.id = $1`,
 		id,
-	).Scan(&d.ID, &d.Subdomain)
-	return &d, err
+	).Scan(&d.ID, &d.ProjectID, &d.ProjectSlug, &d.Subdomain, &d.ImagePort, &d.ProjectPort, &healthChecks, &scaling)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(healthChecks, &d.HealthChecks); err != nil {
+		return nil, fmt.Errorf("invalid health checks: %w", err)
+	}
+	if err := json.Unmarshal(scaling, &d.Scaling); err != nil {
+		return nil, fmt.Errorf("invalid scaling settings: %w", err)
+	}
+	if d.Scaling.MinReplicas < 1 {
+		d.Scaling.MinReplicas = 1
+	}
+	return &d, nil
 }
 
-func (w *Worker) updateDeploymentStatus(id, status string) {
-	_, err := w.db.Exec(
-		"UPDATE deployments SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
-		status, id,
+// getRuntimeEnv loads and decrypts the runtime-scoped env vars of a project
+func (w *Worker) getRuntimeEnv(projectID string) (map[string]string, error) {
+	rows, err := w.db.Query(
+		"SELECT key, value FROM env_vars WHERE project_id = $1 AND scope = 'runtime'",
+		projectID,
 	)
 	if err != nil {
-		log.Printf("Error updating deployment status: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	env := map[string]string{}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		plaintext, err := w.cipher.Decrypt(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", key, err)
+		}
+		env[key] = plaintext
 	}
+	return env, rows.Err()
 }
 
-func (w *Worker) updateDeploymentImage(id, imageURL string) {
+func (w *Worker) updateDeploymentImage(id, imageURL string, port int) {
 	_, err := w.db.Exec(
-		"UPDATE deployments SET image_url = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
-		imageURL, id,
+		"UPDATE deployments SET image_url = $1, port = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3",
+		imageURL, port, id,
 	)
 	if err != nil {
 		log.Printf("Error updating deployment image: %v", err)
 	}
 }
 
-func (w *Worker) updateDeploymentLogs(id, logs string) {
+func (w *Worker) updateDeploymentCommit(id, hash, author, message string) {
+	_, err := w.db.Exec(
+		"UPDATE deployments SET commit_hash = $1, commit_author = $2, commit_message = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4",
+		hash, author, message, id,
+	)
+	if err != nil {
+		log.Printf("Error updating deployment commit: %v", err)
+	}
+}
+
+func (w *Worker) setProductionDeployment(projectID, deploymentID string) {
 	_, err := w.db.Exec(
-		"UPDATE deployments SET build_logs = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
-		logs, id,
+		"UPDATE projects SET production_deployment_id = $1 WHERE id = $2",
+		deploymentID, projectID,
 	)
 	if err != nil {
-		log.Printf("Error updating deployment logs: %v", err)
+		log.Printf("Error updating production deployment: %v", err)
 	}
 }
 
@@ -13,11 +13,24 @@ interface Deployment {
   status: string
   subdomain: string
   image_url: string
+  ref?: string
+  ref_type?: string
   commit_hash: string
-  build_logs: string
+  commit_author?: string
+  commit_message?: string
   created_at: string
 }
 
+interface LogLine {
+  seq: number
+  timestamp: string
+  step: string
+  message: string
+}
+
+// Same format as the lines streamed over the WebSocket
+const formatLogLine = (line: LogLine) => `${line.timestamp} [${line.step}] ${line.message}`
+
 export default function DeploymentDetailPage() {
   const params = useParams()
   const [deployment, setDeployment] = useState<Deployment | null>(null)
@@ -45,9 +58,7 @@ export default function DeploymentDetailPage() {
     try {
       const response = await deployments.getStatus(params.id as string)
       setDeployment(response.data)
-      if (response.data.build_logs) {
-        setLogs(response.data.build_logs.split('\n'))
-      }
+      await loadLogs()
     } catch (error) {
       console.error('Failed to load deployment:', error)
     } finally {
@@ -55,6 +66,27 @@ export default function DeploymentDetailPage() {
     }
   }
 
+  const loadLogs = async () => {
+    const stored: string[] = []
+    let cursor = 0
+    for (;;) {
+      const response = await deployments.listLogs(params.id as string, { cursor, limit: 1000 })
+      stored.push(...(response.data.lines ?? []).map(formatLogLine))
+      if (!response.data.has_more) break
+      cursor = response.data.next_cursor
+    }
+    setLogs((streamed) => [...stored, ...streamed])
+  }
+
+  const cancelDeployment = async () => {
+    try {
+      const response = await deployments.cancel(params.id as string)
+      setDeployment(response.data)
+    } catch (error) {
+      console.error('Failed to cancel deployment:', error)
+    }
+  }
+
   const connectWebSocket = () => {
     try {
       const ws = deployments.connectLogs(params.id as string)
@@ -109,6 +141,14 @@ export default function DeploymentDetailPage() {
           >
             {deployment.status}
           </span>
+          {['pending', 'queued', 'building'].includes(deployment.status) && (
+            <button
+              onClick={cancelDeployment}
+              className="px-4 py-2 rounded-lg text-sm font-medium text-red-600 border border-red-200 hover:bg-red-50"
+            >
+              Cancel
+            </button>
+          )}
         </div>
         
         {deployment.status === 'ready' && (
@@ -136,7 +176,18 @@ export default function DeploymentDetailPage() {
           <h3 className="text-sm text-gray-600 mb-2">Commit</h3>
           <p className="text-lg font-mono text-gray-900">
             {deployment.commit_hash?.slice(0, 7) || 'N/A'}
+            {deployment.ref && deployment.ref_type !== 'commit' && (
+              <span className="ml-2 text-sm text-gray-500">{deployment.ref}</span>
+            )}
           </p>
+          {deployment.commit_message && (
+            <p className="text-sm text-gray-700 mt-1 truncate" title={deployment.commit_message}>
+              {deployment.commit_message.split('\n')[0]}
+            </p>
+          )}
+          {deployment.commit_author && (
+            <p className="text-xs text-gray-500 mt-1">{deployment.commit_author}</p>
+          )}
         </div>
 
         <div className="bg-white rounded-xl p-6 shadow-sm">
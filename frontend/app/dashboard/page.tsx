@@ -135,8 +135,8 @@ function CreateProjectModal({
 }) {
   const [name, setName] = useState('')
   const [repoUrl, setRepoUrl] = useState('')
-  const [buildCommand, setBuildCommand] = useState('npm run build')
-  const [outputDir, setOutputDir] = useState('dist')
+  const [buildCommand, setBuildCommand] = useState('')
+  const [outputDir, setOutputDir] = useState('')
   const [loading, setLoading] = useState(false)
   const [error, setError] = useState('')
 
@@ -209,7 +209,7 @@ function CreateProjectModal({
               value={buildCommand}
               onChange={(e) => setBuildCommand(e.target.value)}
               className="w-full px-4 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent"
-              placeholder="npm run build"
+              placeholder="Detected, e.g. npm run build"
             />
           </div>
 
@@ -222,7 +222,7 @@ function CreateProjectModal({
               value={outputDir}
               onChange={(e) => setOutputDir(e.target.value)}
               className="w-full px-4 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent"
-              placeholder="dist"
+              placeholder="Detected, e.g. dist"
             />
           </div>
 
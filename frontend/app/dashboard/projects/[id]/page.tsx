@@ -21,6 +21,7 @@ interface Deployment {
   status: string
   subdomain: string
   commit_hash: string
+  commit_message?: string
   created_at: string
 }
 
@@ -117,13 +118,13 @@ export default function ProjectDetailPage() {
             <div>
               <dt className="text-sm text-gray-600">Build Command</dt>
               <dd className="text-gray-900 font-mono text-sm">
-                {project.build_command}
+                {project.build_command || 'Detected'}
               </dd>
             </div>
             <div>
               <dt className="text-sm text-gray-600">Output Directory</dt>
               <dd className="text-gray-900 font-mono text-sm">
-                {project.output_dir}
+                {project.output_dir || 'Detected'}
               </dd>
             </div>
             <div>
@@ -188,6 +189,11 @@ export default function ProjectDetailPage() {
                       <span className="text-gray-600 text-sm">
                         {deployment.commit_hash?.slice(0, 7) || 'N/A'}
                       </span>
+                      {deployment.commit_message && (
+                        <span className="text-gray-900 text-sm truncate max-w-md">
+                          {deployment.commit_message.split('\n')[0]}
+                        </span>
+                      )}
                     </div>
                     <p className="text-gray-600 text-sm">
                       {formatDate(deployment.created_at)}
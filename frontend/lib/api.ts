@@ -37,15 +37,25 @@ export const projects = {
     api.put(`/projects/${id}`, data),
   
   delete: (id: string) => api.delete(`/projects/${id}`),
+
+  analyze: (id: string, data?: { ref?: string; ref_type?: 'branch' | 'tag' | 'commit'; root_directory?: string }) =>
+    api.post(`/projects/${id}/analyze`, data ?? {}),
 }
 
 // Deployments
 export const deployments = {
-  trigger: (project_id: string, commit_hash?: string) =>
-    api.post('/deploy', { project_id, commit_hash }),
+  trigger: (project_id: string, ref?: string, ref_type?: 'branch' | 'tag' | 'commit', no_cache?: boolean) =>
+    api.post('/deploy', { project_id, ref, ref_type, no_cache }),
   
   getStatus: (id: string) => api.get(`/deploy/${id}`),
   
+  cancel: (id: string) => api.post(`/deploy/${id}/cancel`),
+  
+  timeline: (id: string) => api.get(`/deploy/${id}/timeline`),
+
+  listLogs: (id: string, params?: { cursor?: number; limit?: number; level?: string; step?: string; q?: string }) =>
+    api.get(`/deploy/${id}/logs`, { params }),
+  
   connectLogs: (id: string) => {
     const WS_URL = process.env.NEXT_PUBLIC_WS_URL || 'ws://localhost:8080'
     return new WebSocket(`${WS_URL}/api/deploy/${id}/logs`)
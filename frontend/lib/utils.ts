@@ -23,8 +23,10 @@ export function getStatusColor(status: string) {
     case 'deploying':
       return 'bg-blue-100 text-blue-800'
     case 'pending':
+    case 'queued':
       return 'bg-yellow-100 text-yellow-800'
     case 'error':
+    case 'timed_out':
       return 'bg-red-100 text-red-800'
     default:
       return 'bg-gray-100 text-gray-800'